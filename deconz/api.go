@@ -1,9 +1,11 @@
 package deconz
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/fasmide/deflux/deconz/event"
 )
@@ -12,54 +14,198 @@ import (
 type API struct {
 	Config      Config
 	sensorCache *CachedSensorStore
+	lightCache  *CachedLightStore
+	groupCache  *CachedGroupStore
+
+	// mu guards client which is created on first use
+	mu     sync.Mutex
+	client *http.Client
 }
 
 // Sensors returns a map of sensors
 func (a *API) Sensors() (*Sensors, error) {
+	var sensors Sensors
+	err := a.get("sensors", &sensors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sensors, nil
+}
+
+// Lights returns a map of lights
+func (a *API) Lights() (*Lights, error) {
+	var lights Lights
+	err := a.get("lights", &lights)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lights, nil
+}
+
+// Groups returns a map of groups
+func (a *API) Groups() (*Groups, error) {
+	var groups Groups
+	err := a.get("groups", &groups)
+	if err != nil {
+		return nil, err
+	}
 
-	url := fmt.Sprintf("%s/%s/sensors", a.Config.Addr, a.Config.APIKey)
-	resp, err := http.Get(url)
+	return &groups, nil
+}
+
+// get decodes the deCONZ resource into v
+func (a *API) get(resource string, v interface{}) error {
+	client, err := a.httpClient()
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/%s/%s", a.Config.Addr, a.Config.APIKey, resource)
+	req, err := a.Config.request(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("unable to get %s: %s", url, err)
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get %s: %s", url, err)
+		return fmt.Errorf("unable to get %s: %s", url, err)
 	}
 
 	defer resp.Body.Close()
 
-	var sensors Sensors
+	// reverse proxies answers with their own errors rather than json
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected statuscode from deCONZ: %d", resp.StatusCode)
+	}
 
 	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(&sensors)
+	err = dec.Decode(v)
 	if err != nil {
-		return nil, fmt.Errorf("unable to decode deCONZ response: %s", err)
+		return fmt.Errorf("unable to decode deCONZ response: %s", err)
 	}
 
-	return &sensors, nil
+	return nil
+}
 
+// httpClient returns the client used for every request to deCONZ
+func (a *API) httpClient() (*http.Client, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.client == nil {
+		client, err := a.Config.client()
+		if err != nil {
+			return nil, err
+		}
+		a.client = client
+	}
+
+	return a.client, nil
 }
 
-// EventReader returns a event.Reader with a default cached type store
-func (a *API) EventReader() (*event.Reader, error) {
+// EventReader returns a event.Reader with a default cached type store. If
+// polling is configured, a Poller is returned instead or as a fallback.
+// The websocket is discovered when dialed, as deCONZ might not be reachable yet
+func (a *API) EventReader() (EventReader, error) {
+	poll := a.Config.Poll
+	if poll.Always {
+		return a.poller(), nil
+	}
 
-	if a.sensorCache == nil {
-		a.sensorCache = &CachedSensorStore{SensorGetter: a}
+	keepalive := a.Config.Keepalive
+	if keepalive == 0 {
+		keepalive = DefaultKeepalive
 	}
+	if keepalive < 0 {
+		keepalive = 0
+	}
+
+	tlsConfig, err := a.Config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &websocketReader{
+		Reader: &event.Reader{
+			TypeStore: a.cache(),
+			Keepalive: keepalive,
+			TLSConfig: tlsConfig,
+			Header:    a.Config.header(),
+		},
+		config: &a.Config,
+	}
+	if poll.FallbackAfter > 0 {
+		return &fallbackReader{primary: reader, fallback: a.poller(), after: poll.FallbackAfter}, nil
+	}
+
+	return reader, nil
+}
 
-	if a.Config.wsAddr == "" {
-		err := a.Config.discoverWebsocket()
+// websocketReader is an event.Reader discovering the websocket address on
+// the first successful dial
+type websocketReader struct {
+	*event.Reader
+	config *Config
+}
+
+// Dial discovers the websocket address if needed and dials it, failing to
+// discover it is retried like failing to dial
+func (w *websocketReader) Dial() error {
+	if w.WebsocketAddr == "" {
+		addr, err := w.config.discoverWebsocket()
 		if err != nil {
-			return nil, err
+			return err
 		}
+		w.WebsocketAddr = addr
+	}
+
+	return w.Reader.Dial()
+}
+
+// poller returns a Poller polling sensors every Config.Poll.Interval
+func (a *API) poller() *Poller {
+	return &Poller{SensorGetter: a, Interval: a.Config.Poll.Interval}
+}
+
+// SensorEventReader takes an event reader and returns an sensor event reader,
+// light and group events are looked up through their own caches
+func (a *API) SensorEventReader(r EventReader) *SensorEventReader {
+	if a.lightCache == nil {
+		a.lightCache = &CachedLightStore{LightGetter: a}
+	}
+	if a.groupCache == nil {
+		a.groupCache = &CachedGroupStore{GroupGetter: a}
 	}
 
-	return &event.Reader{TypeStore: a.sensorCache, WebsocketAddr: a.Config.wsAddr}, nil
+	return &SensorEventReader{
+		lookup:    a.cache(),
+		lights:    a.lightCache,
+		groups:    a.groupCache,
+		reader:    r,
+		gateway:   a.Config.Name,
+		reconnect: a.Config.Reconnect,
+	}
 }
 
-// SensorEventReader takes an event reader and returns an sensor event reader
-func (a *API) SensorEventReader(r *event.Reader) *SensorEventReader {
+// RefreshSensors refreshes the sensor cache every Config.SensorRefresh until
+// ctx is cancelled, sensors added or renamed since are picked up this way
+// even if we never saw an event about it
+func (a *API) RefreshSensors(ctx context.Context) {
+	d := a.Config.SensorRefresh
+	if d == 0 {
+		d = DefaultSensorRefresh
+	}
+
+	a.cache().RefreshEvery(ctx, d)
+}
 
+// cache returns the sensor cache, creating it if needed
+func (a *API) cache() *CachedSensorStore {
 	if a.sensorCache == nil {
 		a.sensorCache = &CachedSensorStore{SensorGetter: a}
 	}
 
-	return &SensorEventReader{lookup: a.sensorCache, reader: r}
+	return a.sensorCache
 }
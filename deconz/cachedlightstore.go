@@ -0,0 +1,117 @@
+package deconz
+
+import (
+	"errors"
+	"time"
+)
+
+// LightGetter defines how we like to ask for lights
+type LightGetter interface {
+	Lights() (*Lights, error)
+}
+
+// GroupGetter defines how we like to ask for groups
+type GroupGetter interface {
+	Groups() (*Groups, error)
+}
+
+// CachedLightStore caches lights for looking up light events, unknown ids
+// are refetched at most every RefetchInterval
+type CachedLightStore struct {
+	LightGetter
+	RefetchInterval time.Duration
+
+	cache resourceCache
+}
+
+// LookupLight returns a light for a light id, if the id is unknown
+// lights are refetched as it could have been added since we last fetched them
+func (c *CachedLightStore) LookupLight(i int) (*Light, error) {
+	v, err := c.cache.lookup(i, "lights", c.RefetchInterval, c.fetch, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	l := v.(Light)
+	return &l, nil
+}
+
+// Invalidate makes the next lookup refetch lights, regardless of RefetchInterval
+func (c *CachedLightStore) Invalidate() {
+	c.cache.invalidate()
+}
+
+func (c *CachedLightStore) fetch() (map[int]interface{}, error) {
+	lights, err := c.Lights()
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[int]interface{}, len(*lights))
+	for id, l := range *lights {
+		m[id] = l
+	}
+	return m, nil
+}
+
+// CachedGroupStore caches groups for looking up group events, unknown ids
+// are refetched at most every RefetchInterval
+type CachedGroupStore struct {
+	GroupGetter
+	RefetchInterval time.Duration
+
+	cache resourceCache
+}
+
+// LookupGroup returns a group for a group id, if the id is unknown
+// groups are refetched as it could have been added since we last fetched them
+func (c *CachedGroupStore) LookupGroup(i int) (*Group, error) {
+	v, err := c.cache.lookup(i, "groups", c.RefetchInterval, c.fetch, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	g := v.(Group)
+	return &g, nil
+}
+
+// LookupScene returns scene s of group g, if the group or scene is unknown
+// groups are refetched as it could have been stored since we last fetched them
+func (c *CachedGroupStore) LookupScene(g, s int) (*Group, *Scene, error) {
+	hasScene := func(v interface{}) bool {
+		group := v.(Group)
+		_, found := group.Scene(s)
+		return found
+	}
+
+	v, err := c.cache.lookup(g, "groups", c.RefetchInterval, c.fetch, hasScene)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	group := v.(Group)
+	scene, found := group.Scene(s)
+	if !found {
+		return nil, nil, errors.New("no such scene")
+	}
+
+	return &group, scene, nil
+}
+
+// Invalidate makes the next lookup refetch groups, regardless of RefetchInterval
+func (c *CachedGroupStore) Invalidate() {
+	c.cache.invalidate()
+}
+
+func (c *CachedGroupStore) fetch() (map[int]interface{}, error) {
+	groups, err := c.Groups()
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[int]interface{}, len(*groups))
+	for id, g := range *groups {
+		m[id] = g
+	}
+	return m, nil
+}
@@ -0,0 +1,57 @@
+package deconz
+
+import (
+	"testing"
+	"time"
+)
+
+// countingGroupGetter returns groups and counts how many times it was asked
+type countingGroupGetter struct {
+	groups Groups
+	calls  int
+}
+
+func (c *countingGroupGetter) Groups() (*Groups, error) {
+	c.calls++
+
+	// hand out a copy, the store should not see later changes without refetching
+	g := make(Groups)
+	for k, v := range c.groups {
+		v.Scenes = append([]Scene{}, v.Scenes...)
+		g[k] = v
+	}
+	return &g, nil
+}
+
+func TestCachedGroupStoreScene(t *testing.T) {
+	g := &countingGroupGetter{groups: Groups{1: Group{Name: "Living room"}}}
+	c := &CachedGroupStore{GroupGetter: g, RefetchInterval: time.Hour}
+
+	_, err := c.LookupGroup(1)
+	if err != nil {
+		t.Fatalf("unable to lookup known group: %s", err)
+	}
+
+	// a scene is stored after we populated the cache
+	group := g.groups[1]
+	group.Scenes = []Scene{{ID: 2, Name: "Movie"}}
+	g.groups[1] = group
+
+	// the cache was just populated, the refetch should be rate limited
+	_, _, err = c.LookupScene(1, 2)
+	if err == nil {
+		t.Errorf("expected scene lookup to be rate limited")
+	}
+	if g.calls != 1 {
+		t.Errorf("expected 1 call to Groups, got %d", g.calls)
+	}
+
+	c.RefetchInterval = time.Nanosecond
+	_, scene, err := c.LookupScene(1, 2)
+	if err != nil {
+		t.Fatalf("unable to lookup new scene: %s", err)
+	}
+	if scene.Name != "Movie" {
+		t.Errorf("unexpected scene %v", scene)
+	}
+}
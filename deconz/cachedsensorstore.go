@@ -1,16 +1,27 @@
 package deconz
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"time"
+
+	"github.com/fasmide/deflux/deconz/event"
 )
 
+// DefaultRefetchInterval is the default minimum time between refetching
+// sensors because an unknown sensor id was looked up
+const DefaultRefetchInterval = 30 * time.Second
+
 // CachedSensorStore is a cached typestore which provides LookupType for event passing
 // it will be our default store
 type CachedSensorStore struct {
 	SensorGetter
-	cache *Sensors
+	// RefetchInterval rate limits refetching sensors when looking up unknown ids
+	RefetchInterval time.Duration
+
+	cache resourceCache
 }
 
 // SensorGetter defines how we like to ask for sensors
@@ -19,49 +30,102 @@ type SensorGetter interface {
 }
 
 // LookupType lookups deCONZ event types though a cache
-// TODO: if we where unable to lookup an ID we should try to refetch the cache
-// - there could have been an sensor added we dont know about
 func (c *CachedSensorStore) LookupType(i int) (string, error) {
-	var err error
-	if c.cache == nil {
-		err = c.populateCache()
-		if err != nil {
-			return "", fmt.Errorf("unable to populate sensors: %s", err)
-		}
+	s, err := c.LookupSensor(i)
+	if err != nil {
+		return "", err
 	}
 
-	if s, found := (*c.cache)[i]; found {
-		return s.Type, nil
+	return s.Type, nil
+}
+
+// LookupSensor returns a sensor for an sensor id, if the id is unknown
+// sensors are refetched as it could have been added since we last fetched them
+func (c *CachedSensorStore) LookupSensor(i int) (*Sensor, error) {
+	v, err := c.cache.lookup(i, "sensors", c.RefetchInterval, c.fetch, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	return "", errors.New("no such sensor")
+	s := v.(Sensor)
+	return &s, nil
 }
 
-// LookupSensor returns a sensor for an sensor id
-func (c *CachedSensorStore) LookupSensor(i int) (*Sensor, error) {
-	var err error
-	if c.cache == nil {
-		err = c.populateCache()
+// Invalidate makes the next lookup refetch sensors, regardless of RefetchInterval
+func (c *CachedSensorStore) Invalidate() {
+	c.cache.invalidate()
+}
+
+// Update applies the name and config carried by a changed event to the cached
+// sensor, config is merged as deCONZ only sends what changed
+func (c *CachedSensorStore) Update(e *event.Event) error {
+	var config map[string]interface{}
+	if len(e.RawConfig) > 0 {
+		err := json.Unmarshal(e.RawConfig, &config)
 		if err != nil {
-			return nil, fmt.Errorf("unable to populate sensors: %s", err)
+			return fmt.Errorf("unable to unmarshal config: %s", err)
 		}
 	}
 
-	if s, found := (*c.cache)[i]; found {
-		return &s, nil
-	}
+	return c.cache.update(e.ID, "sensors", func(v interface{}) interface{} {
+		s := v.(Sensor)
 
-	return nil, errors.New("no such sensor")
+		if e.NewName != "" && e.NewName != s.Name {
+			log.Printf("Sensor %d renamed from %s to %s", e.ID, s.Name, e.NewName)
+			s.Name = e.NewName
+		}
+
+		if len(config) > 0 {
+			// copy the config, sensors handed out by lookups must not change under their holders
+			merged := make(map[string]interface{}, len(s.Config)+len(config))
+			for k, v := range s.Config {
+				merged[k] = v
+			}
+			for k, v := range config {
+				merged[k] = v
+			}
+			s.Config = merged
+		}
+
+		return s
+	})
 }
 
-func (c *CachedSensorStore) populateCache() error {
-	var err error
-	c.cache, err = c.Sensors()
+// Refresh refetches sensors
+func (c *CachedSensorStore) Refresh() error {
+	return c.cache.refresh(c.fetch)
+}
+
+// RefreshEvery refreshes sensors every d until ctx is cancelled
+func (c *CachedSensorStore) RefreshEvery(ctx context.Context, d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := c.Refresh()
+			if err != nil {
+				log.Printf("unable to refresh sensors: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *CachedSensorStore) fetch() (map[int]interface{}, error) {
+	sensors, err := c.Sensors()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	m := make(map[int]interface{}, len(*sensors))
+	for id, s := range *sensors {
+		m[id] = s
 	}
 
-	log.Printf("SensorStore updated, found %d sensors", len((*c.cache)))
+	log.Printf("SensorStore updated, found %d sensors", len(m))
 
-	return nil
+	return m, nil
 }
@@ -0,0 +1,112 @@
+package deconz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fasmide/deflux/deconz/event"
+)
+
+// countingGetter returns sensors and counts how many times it was asked
+type countingGetter struct {
+	sensors Sensors
+	calls   int
+}
+
+func (c *countingGetter) Sensors() (*Sensors, error) {
+	c.calls++
+
+	// hand out a copy, the store should not see later changes without refetching
+	s := make(Sensors)
+	for k, v := range c.sensors {
+		s[k] = v
+	}
+	return &s, nil
+}
+
+func TestCachedSensorStoreRefetch(t *testing.T) {
+	g := &countingGetter{sensors: Sensors{1: Sensor{Name: "Kitchen", Type: "ZHATemperature"}}}
+	c := &CachedSensorStore{SensorGetter: g, RefetchInterval: time.Hour}
+
+	_, err := c.LookupSensor(1)
+	if err != nil {
+		t.Fatalf("unable to lookup known sensor: %s", err)
+	}
+
+	// a sensor is paired after we populated the cache
+	g.sensors[2] = Sensor{Name: "Bathroom", Type: "ZHAHumidity"}
+
+	// the cache was just populated, the refetch should be rate limited
+	_, err = c.LookupSensor(2)
+	if err == nil {
+		t.Errorf("expected lookup to be rate limited")
+	}
+	if g.calls != 1 {
+		t.Errorf("expected 1 call to Sensors, got %d", g.calls)
+	}
+
+	c.RefetchInterval = time.Nanosecond
+	s, err := c.LookupSensor(2)
+	if err != nil {
+		t.Fatalf("unable to lookup new sensor: %s", err)
+	}
+	if s.Name != "Bathroom" {
+		t.Errorf("unexpected sensor %v", s)
+	}
+}
+
+func TestCachedSensorStoreInvalidate(t *testing.T) {
+	g := &countingGetter{sensors: Sensors{1: Sensor{Name: "Kitchen", Type: "ZHATemperature"}}}
+	c := &CachedSensorStore{SensorGetter: g, RefetchInterval: time.Hour}
+
+	c.LookupSensor(1)
+
+	g.sensors[2] = Sensor{Name: "Bathroom", Type: "ZHAHumidity"}
+	c.Invalidate()
+
+	typ, err := c.LookupType(2)
+	if err != nil {
+		t.Fatalf("unable to lookup sensor after invalidation: %s", err)
+	}
+	if typ != "ZHAHumidity" {
+		t.Errorf("unexpected type %s", typ)
+	}
+	if g.calls != 2 {
+		t.Errorf("expected 2 calls to Sensors, got %d", g.calls)
+	}
+}
+
+func TestCachedSensorStoreUpdate(t *testing.T) {
+	g := &countingGetter{sensors: Sensors{6: Sensor{
+		Name:   "lumi.sensor_wleak.aq1",
+		Type:   "ZHAWater",
+		Config: map[string]interface{}{"battery": float64(100), "on": true},
+	}}}
+	c := &CachedSensorStore{SensorGetter: g}
+
+	before, _ := c.LookupSensor(6)
+
+	d := event.Decoder{TypeStore: c}
+	e, err := d.Parse([]byte(`{"config":{"battery":95},"name":"Washing machine","e":"changed","id":"6","r":"sensors","t":"event"}`))
+	if err != nil {
+		t.Fatalf("unable to parse event: %s", err)
+	}
+
+	err = c.Update(e)
+	if err != nil {
+		t.Fatalf("unable to update sensor: %s", err)
+	}
+
+	s, _ := c.LookupSensor(6)
+	if s.Name != "Washing machine" {
+		t.Errorf("sensor was not renamed: %s", s.Name)
+	}
+	if s.Config["battery"] != float64(95) || s.Config["on"] != true {
+		t.Errorf("config was not merged: %v", s.Config)
+	}
+
+	// sensors handed out before the update should not change
+	if before.Name != "lumi.sensor_wleak.aq1" || before.Config["battery"] != float64(100) {
+		t.Errorf("previously looked up sensor changed: %v", before)
+	}
+}
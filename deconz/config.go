@@ -1,18 +1,58 @@
 package deconz
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
+	"time"
 )
 
+// DefaultSensorRefresh is how often sensors are refreshed by default
+const DefaultSensorRefresh = 10 * time.Minute
+
 // Config represents a Deconz gateway
 type Config struct {
+	// Name is added as a gateway tag to everything read from this gateway,
+	// it is required when reading from multiple gateways
+	Name   string `yaml:",omitempty"`
 	Addr   string
 	APIKey string
-	wsAddr string
+	// SensorRefresh is how often the sensor cache is refreshed
+	SensorRefresh time.Duration `yaml:",omitempty"`
+	// Reconnect configures the delays between redialing the websocket
+	Reconnect ReconnectConfig `yaml:",omitempty"`
+	// Keepalive is how often the websocket is pinged to detect half open
+	// connections, it defaults to 30s and a negative value disables pinging
+	Keepalive time.Duration `yaml:",omitempty"`
+	// Poll configures polling sensors instead of, or as a fallback for, the websocket
+	Poll PollConfig `yaml:",omitempty"`
+	// WebsocketAddr overrides the discovered websocket address, e.g.
+	// wss://deconz.example.com/websocket behind a reverse proxy
+	WebsocketAddr string `yaml:",omitempty"`
+	// TLS configures certificates used for https and wss
+	TLS TLSConfig `yaml:",omitempty"`
+	// Headers are added to every request to deCONZ and the websocket handshake,
+	// e.g. for authenticating with a reverse proxy
+	Headers map[string]string `yaml:",omitempty"`
+}
+
+// TLSConfig configures how deCONZ is reached over TLS
+type TLSConfig struct {
+	// CA is a pem file of certificates to verify deCONZ with instead of the
+	// system certificates, e.g. for a self-signed certificate
+	CA string `yaml:",omitempty"`
+	// InsecureSkipVerify disables verifying the certificate of deCONZ
+	InsecureSkipVerify bool `yaml:",omitempty"`
+	// Cert and Key are pem files of a client certificate presented to deCONZ
+	Cert string `yaml:",omitempty"`
+	Key  string `yaml:",omitempty"`
 }
 
 // config is used to parse the things we need from the deCONZ config endpoint
@@ -20,16 +60,23 @@ type config struct {
 	Websocketport int
 }
 
-func (c *Config) discoverWebsocket() error {
+// discoverWebsocket returns the websocket address, asking deCONZ for its port
+// unless WebsocketAddr is configured
+func (c *Config) discoverWebsocket() (string, error) {
+	// the websocket port of deCONZ is of no use behind a reverse proxy
+	if c.WebsocketAddr != "" {
+		return c.WebsocketAddr, nil
+	}
+
 	u, err := url.Parse(c.Addr)
 	if err != nil {
-		return fmt.Errorf("unable to discover websocket: %s", err)
+		return "", fmt.Errorf("unable to discover websocket: %s", err)
 	}
 	u.Path = path.Join(u.Path, c.APIKey, "config")
 
-	resp, err := http.Get(u.String())
+	resp, err := c.get(u.String())
 	if err != nil {
-		return fmt.Errorf("unable to discover websocket: %s", err)
+		return "", fmt.Errorf("unable to discover websocket: %s", err)
 	}
 	defer resp.Body.Close()
 
@@ -38,14 +85,92 @@ func (c *Config) discoverWebsocket() error {
 	var conf config
 	err = dec.Decode(&conf)
 	if err != nil {
-		return fmt.Errorf("unable to discover websocket: %s", err)
+		return "", fmt.Errorf("unable to discover websocket: %s", err)
 	}
 
 	// change our old parsed url to websocket, it should connect to the websocket endpoint of deCONZ
-	u.Scheme = "ws"
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
 	u.Path = "/"
 	u.Host = fmt.Sprintf("%s:%d", u.Hostname(), conf.Websocketport)
 
-	c.wsAddr = u.String()
-	return nil
+	return u.String(), nil
+}
+
+// tlsConfig loads the certificates configured in c.TLS
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	conf := &tls.Config{InsecureSkipVerify: c.TLS.InsecureSkipVerify}
+
+	if c.TLS.CA != "" {
+		ca, err := ioutil.ReadFile(c.TLS.CA)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ca: %s", err)
+		}
+
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("unable to read ca: no certificates found")
+		}
+	}
+
+	if c.TLS.Cert != "" || c.TLS.Key != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.Cert, c.TLS.Key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
+
+// header returns c.Headers as a http.Header
+func (c *Config) header() http.Header {
+	h := make(http.Header, len(c.Headers))
+	for k, v := range c.Headers {
+		h.Set(k, v)
+	}
+	return h
+}
+
+// client returns a http client using the certificates configured in c.TLS
+func (c *Config) client() (*http.Client, error) {
+	conf, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = conf
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// get sends a single GET request to deCONZ, repeated requests should reuse a client
+func (c *Config) get(url string) (*http.Response, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.request(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Do(req)
+}
+
+// request returns a request carrying c.Headers
+func (c *Config) request(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = c.header()
+	return req, nil
 }
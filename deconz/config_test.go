@@ -0,0 +1,135 @@
+package deconz
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// tlsProxy is a https reverse proxy in front of deCONZ, requiring a token header
+func tlsProxy() *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/deconz/api/key/sensors", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"1":{"name":"Attic","type":"ZHATemperature"}}`))
+	})
+	mux.HandleFunc("/deconz/websocket", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	})
+
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func TestTLS(t *testing.T) {
+	server := tlsProxy()
+	defer server.Close()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatalf("unable to write ca: %s", err)
+	}
+
+	api := &API{Config: Config{
+		Addr:          server.URL + "/deconz/api",
+		APIKey:        "key",
+		WebsocketAddr: "wss" + strings.TrimPrefix(server.URL, "https") + "/deconz/websocket",
+		TLS:           TLSConfig{CA: ca},
+		Headers:       map[string]string{"X-Token": "secret"},
+	}}
+
+	sensors, err := api.Sensors()
+	if err != nil {
+		t.Fatalf("unable to get sensors: %s", err)
+	}
+	if (*sensors)[1].Name != "Attic" {
+		t.Errorf("unexpected sensors: %v", sensors)
+	}
+
+	reader, err := api.EventReader()
+	if err != nil {
+		t.Fatalf("unable to create event reader: %s", err)
+	}
+
+	err = reader.Dial()
+	if err != nil {
+		t.Fatalf("unable to dial websocket: %s", err)
+	}
+	reader.Close()
+
+	// without the header the proxy refuses us
+	api = &API{Config: Config{Addr: api.Config.Addr, APIKey: "key", TLS: TLSConfig{CA: ca}}}
+	_, err = api.Sensors()
+	if err == nil {
+		t.Errorf("expected requests without headers to be refused")
+	}
+
+	// and without the ca the certificate is unknown
+	api = &API{Config: Config{Addr: api.Config.Addr, APIKey: "key", Headers: map[string]string{"X-Token": "secret"}}}
+	_, err = api.Sensors()
+	if err == nil {
+		t.Errorf("expected the self-signed certificate to be refused")
+	}
+}
+
+func TestDiscoverOnDial(t *testing.T) {
+	var ready int32
+	upgrader := websocket.Upgrader{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/key/config" {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+
+		// deCONZ is still starting
+		if atomic.LoadInt32(&ready) == 0 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+
+		u, _ := url.Parse(server.URL)
+		fmt.Fprintf(w, `{"websocketport":%s}`, u.Port())
+	}))
+	defer server.Close()
+
+	api := &API{Config: Config{Addr: server.URL + "/api", APIKey: "key"}}
+	reader, err := api.EventReader()
+	if err != nil {
+		t.Fatalf("an unreachable gateway should not prevent creating an event reader: %s", err)
+	}
+
+	err = reader.Dial()
+	if err == nil {
+		t.Fatalf("expected dialing to fail before the websocket can be discovered")
+	}
+
+	atomic.StoreInt32(&ready, 1)
+	err = reader.Dial()
+	if err != nil {
+		t.Fatalf("unable to dial discovered websocket: %s", err)
+	}
+	reader.Close()
+}
@@ -0,0 +1,44 @@
+package deconz
+
+import "sync"
+
+// counter is implemented by states reporting a cumulative counter
+type counter interface {
+	Counter() int64
+	SetDelta(int64)
+}
+
+// counters remembers the last reading of cumulative counters, turning
+// them into deltas which are safe to sum across counter resets
+type counters struct {
+	mu   sync.Mutex
+	last map[int]int64
+}
+
+// track sets the delta since the previous reading of the sensor with the
+// given id. A counter going backwards means the device was reset and
+// started counting from zero, in that case the reading itself is the delta.
+// The first reading has no delta as we cannot know what happened before it
+func (c *counters) track(id int, s counter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.last == nil {
+		c.last = make(map[int]int64)
+	}
+
+	current := s.Counter()
+	previous, found := c.last[id]
+	c.last[id] = current
+
+	if !found {
+		return
+	}
+
+	if current < previous {
+		s.SetDelta(current)
+		return
+	}
+
+	s.SetDelta(current - previous)
+}
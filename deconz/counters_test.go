@@ -0,0 +1,27 @@
+package deconz
+
+import (
+	"testing"
+
+	"github.com/fasmide/deflux/deconz/event"
+)
+
+func TestCounters(t *testing.T) {
+	var c counters
+
+	// consumption readings of a plug rebooted between 1500 and 20
+	readings := []int64{1000, 1500, 20, 70}
+	var deltas []interface{}
+	for _, reading := range readings {
+		s := &event.ZHAConsumption{Consumption: reading}
+		c.track(3, s)
+		deltas = append(deltas, s.Fields()["consumption_delta"])
+	}
+
+	expected := []interface{}{nil, int64(500), int64(20), int64(50)}
+	for i := range expected {
+		if deltas[i] != expected[i] {
+			t.Errorf("reading %d: expected delta %v, got %v", readings[i], expected[i], deltas[i])
+		}
+	}
+}
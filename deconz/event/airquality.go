@@ -0,0 +1,89 @@
+package event
+
+func init() {
+	Register(func() interface{} { return &ZHAAirQuality{} }, "ZHAAirQuality")
+	Register(func() interface{} { return &ZHACarbonDioxide{} }, "ZHACarbonDioxide")
+	Register(func() interface{} { return &ZHAParticulateMatter{} }, "ZHAParticulateMatter")
+	Register(func() interface{} { return &ZHAFormaldehyde{} }, "ZHAFormaldehyde")
+}
+
+// AirQualityLevels maps the textual air quality levels of deCONZ to an
+// ordinal, higher is worse
+var AirQualityLevels = map[string]int{
+	"excellent":    1,
+	"good":         2,
+	"moderate":     3,
+	"poor":         4,
+	"unhealthy":    5,
+	"out of scale": 6,
+}
+
+// airQualityFields adds the textual level and its ordinal, if known
+func airQualityFields(f map[string]interface{}, level string) {
+	if level == "" {
+		return
+	}
+
+	f["airquality"] = level
+	if ordinal, ok := AirQualityLevels[level]; ok {
+		f["airquality_level"] = ordinal
+	}
+}
+
+// ZHAAirQuality represents a change from a VOC sensor
+type ZHAAirQuality struct {
+	State
+	Airquality    string
+	Airqualityppb int
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAAirQuality) Fields() map[string]interface{} {
+	f := map[string]interface{}{
+		"airqualityppb": z.Airqualityppb,
+	}
+	airQualityFields(f, z.Airquality)
+	return f
+}
+
+// ZHACarbonDioxide represents a change from a CO2 sensor, measured in ppm
+type ZHACarbonDioxide struct {
+	State
+	MeasuredValue int `json:"measured_value"`
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHACarbonDioxide) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"co2": z.MeasuredValue,
+	}
+}
+
+// ZHAParticulateMatter represents a change from a PM2.5 sensor, measured in µg/m³
+type ZHAParticulateMatter struct {
+	State
+	MeasuredValue int `json:"measured_value"`
+	Airquality    string
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAParticulateMatter) Fields() map[string]interface{} {
+	f := map[string]interface{}{
+		"pm2_5": z.MeasuredValue,
+	}
+	airQualityFields(f, z.Airquality)
+	return f
+}
+
+// ZHAFormaldehyde represents a change from a formaldehyde sensor
+type ZHAFormaldehyde struct {
+	State
+	MeasuredValue int `json:"measured_value"`
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAFormaldehyde) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"formaldehyde": z.MeasuredValue,
+	}
+}
@@ -0,0 +1,40 @@
+package event
+
+func init() {
+	// virtual sensors created through the REST API, those with a ZHA
+	// counterpart are decoded by it so their fields match
+	Register(func() interface{} { return &CLIPGenericFlag{} }, "CLIPGenericFlag")
+	Register(func() interface{} { return &CLIPGenericStatus{} }, "CLIPGenericStatus")
+	Register(func() interface{} { return &ZHASwitch{} }, "CLIPSwitch")
+	Register(func() interface{} { return &ZHATemperature{} }, "CLIPTemperature")
+	Register(func() interface{} { return &ZHAHumidity{} }, "CLIPHumidity")
+	Register(func() interface{} { return &ZHAPressure{} }, "CLIPPressure")
+	Register(func() interface{} { return &ZHAOpenClose{} }, "CLIPOpenClose")
+	Register(func() interface{} { return &ZHALightLevel{} }, "CLIPLightLevel")
+}
+
+// CLIPGenericFlag represents a change of a virtual flag
+type CLIPGenericFlag struct {
+	State
+	Flag bool
+}
+
+// Fields returns timeseries data for influxdb
+func (c *CLIPGenericFlag) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"flag": c.Flag,
+	}
+}
+
+// CLIPGenericStatus represents a change of a virtual status
+type CLIPGenericStatus struct {
+	State
+	Status int
+}
+
+// Fields returns timeseries data for influxdb
+func (c *CLIPGenericStatus) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"status": c.Status,
+	}
+}
@@ -0,0 +1,63 @@
+package event
+
+func init() {
+	Register(func() interface{} { return &ZHAConsumption{} }, "ZHAConsumption")
+	Register(func() interface{} { return &ZHAPower{} }, "ZHAPower")
+}
+
+// ZHAConsumption represents a change from an energy meter
+type ZHAConsumption struct {
+	State
+	// Consumption is a counter of consumed energy in Wh
+	Consumption int64
+	// Power is the current power in W, not every meter reports it
+	Power *int
+	// Delta is the energy in Wh consumed since the previous reading,
+	// it is not reported by deCONZ but must be set by whoever tracks readings
+	Delta *int64 `json:"-"`
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAConsumption) Fields() map[string]interface{} {
+	f := map[string]interface{}{
+		"consumption":     z.Consumption,
+		"consumption_kwh": float64(z.Consumption) / 1000,
+	}
+	if z.Power != nil {
+		f["power"] = *z.Power
+	}
+	if z.Delta != nil {
+		f["consumption_delta"] = *z.Delta
+	}
+	return f
+}
+
+// Counter returns the consumption counter
+func (z *ZHAConsumption) Counter() int64 {
+	return z.Consumption
+}
+
+// SetDelta sets the energy consumed since the previous reading
+func (z *ZHAConsumption) SetDelta(d int64) {
+	z.Delta = &d
+}
+
+// ZHAPower represents a change from a power meter
+type ZHAPower struct {
+	State
+	// Power is in W
+	Power int
+	// Voltage is in V
+	Voltage int
+	// Current is in mA
+	Current int
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAPower) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"power":   z.Power,
+		"voltage": z.Voltage,
+		"current": float64(z.Current) / 1000,
+	}
+}
@@ -2,7 +2,10 @@ package event
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"time"
 )
 
 // TypeLookuper is the interface that we require to lookup types from id's
@@ -10,7 +13,7 @@ type TypeLookuper interface {
 	LookupType(int) (string, error)
 }
 
-// Event represents a deconz sensor event
+// Event represents a deconz event
 type Event struct {
 	Type     string          `json:"t"`
 	Event    string          `json:"e"`
@@ -18,11 +21,30 @@ type Event struct {
 	ID       int             `json:"id,string"`
 	RawState json.RawMessage `json:"state"`
 	State    interface{}
+	// NewName and RawConfig are only present when a sensor was renamed or reconfigured
+	NewName   string          `json:"name"`
+	RawConfig json.RawMessage `json:"config"`
+	Config    *Config
+	// GroupID and SceneID are only present in scene-called events
+	GroupID int `json:"gid,string"`
+	SceneID int `json:"scid,string"`
 }
 
 // Decoder is able to decode deCONZ events
 type Decoder struct {
 	TypeStore TypeLookuper
+	// Registry decodes states, DefaultRegistry is used if it is nil
+	Registry Registry
+}
+
+// Register extends the decoder with a state constructor for one or more
+// deCONZ types, other decoders are not affected
+func (d *Decoder) Register(newState func() interface{}, types ...string) {
+	if d.Registry == nil {
+		d.Registry = DefaultRegistry.Copy()
+	}
+
+	d.Registry.Register(newState, types...)
 }
 
 // Parse parses events from bytes
@@ -33,15 +55,49 @@ func (d *Decoder) Parse(b []byte) (*Event, error) {
 		return nil, fmt.Errorf("unable to unmarshal json: %s", err)
 	}
 
+	// config updates usually comes without state, e.g. battery updates
+	if e.Resource == "sensors" && len(e.RawConfig) > 0 {
+		var c Config
+		err = UnmarshalLenient(e.RawConfig, &c)
+		if err != nil {
+			return nil, fmt.Errorf("unable to unmarshal config: %s", err)
+		}
+		e.Config = &c
+	}
+
 	// If there is no state, dont try to parse it
-	// TODO: figure out what to do with these
-	//       some of them seems to be battery updates
-	if e.Resource != "sensors" || len(e.RawState) == 0 {
+	if len(e.RawState) == 0 {
 		e.State = &EmptyState{}
 		return &e, nil
 	}
 
-	err = e.ParseState(d.TypeStore)
+	// lights and groups have the same state regardless of their type
+	switch e.Resource {
+	case "sensors":
+	case "lights":
+		e.State = &LightState{}
+		err = json.Unmarshal(e.RawState, e.State)
+	case "groups":
+		e.State = &GroupState{}
+		err = json.Unmarshal(e.RawState, e.State)
+	default:
+		e.State = &EmptyState{}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %s state: %s", e.Resource, err)
+	}
+
+	if e.Resource != "sensors" {
+		return &e, nil
+	}
+
+	registry := d.Registry
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+
+	err = e.parseState(d.TypeStore, registry)
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshal state: %s", err)
 	}
@@ -52,91 +108,116 @@ func (d *Decoder) Parse(b []byte) (*Event, error) {
 // ParseState tries to unmarshal the appropriate state based
 // on looking up the id though the TypeStore
 func (e *Event) ParseState(tl TypeLookuper) error {
+	return e.parseState(tl, DefaultRegistry)
+}
+
+func (e *Event) parseState(tl TypeLookuper, r Registry) error {
 
 	t, err := tl.LookupType(e.ID)
 	if err != nil {
 		return fmt.Errorf("unable to lookup event id %d: %s", e.ID, err)
 	}
 
-	switch t {
-	case "ZHAFire":
-		var s ZHAFire
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHATemperature":
-		var s ZHATemperature
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAPressure":
-		var s ZHAPressure
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAHumidity":
-		var s ZHAHumidity
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAWater":
-		var s ZHAWater
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHASwitch":
-		var s ZHASwitch
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "Daylight":
-		var s Daylight
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAPresence":
-		var s ZHAPresence
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "CLIPPresence":
-		var s CLIPPresence
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHALightLevel":
-		var s ZHALightLevel
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAVibration":
-		var s ZHAVibration
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHAOpenClose":
-		var s ZHAOpenClose
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	case "ZHACarbonMonoxide":
-		var s ZHACarbonMonoxide
-		err = json.Unmarshal(e.RawState, &s)
-		e.State = &s
-		break
-	default:
-		err = fmt.Errorf("unable to unmarshal event state: %s is not a known type", t)
-	}
-
-	// err should continue to be null if everythings ok
+	e.State, err = r.Decode(t, e.RawState)
 	return err
 }
 
+// LastupdatedLayout is the layout deCONZ uses for lastupdated timestamps, they are
+// always in UTC and carry no zone. Milliseconds, when present, are accepted by
+// time.Parse even though the layout does not mention them
+const LastupdatedLayout = "2006-01-02T15:04:05"
+
 // State is for embedding into event states
 type State struct {
 	Lastupdated string
 }
 
+// Time parses Lastupdated into a time.Time
+func (s *State) Time() (time.Time, error) {
+	// deCONZ reports "none" for sensors that have never been updated
+	if s.Lastupdated == "" || s.Lastupdated == "none" {
+		return time.Time{}, errors.New("state has no lastupdated")
+	}
+
+	t, err := time.ParseInLocation(LastupdatedLayout, s.Lastupdated, time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse lastupdated: %s", err)
+	}
+
+	return t, nil
+}
+
+// Config is the config of a sensor, deCONZ only sends what has changed
+// so fields not sent are nil
+type Config struct {
+	Battery     *int
+	On          *bool
+	Reachable   *bool
+	Temperature *int
+	// Heatsetpoint, Mode and Offset are only reported by thermostats
+	Heatsetpoint *int
+	Mode         *string
+	Offset       *int
+}
+
+// UnmarshalLenient unmarshals a json object into v, which must be a pointer
+// to a struct. Keys with a value of an unexpected type are skipped instead of
+// failing the whole object, devices disagree on the types of some config keys
+func UnmarshalLenient(raw json.RawMessage, v interface{}) error {
+	var keys map[string]json.RawMessage
+	err := json.Unmarshal(raw, &keys)
+	if err != nil {
+		return err
+	}
+
+	t := reflect.TypeOf(v).Elem()
+	for k, value := range keys {
+		single, err := json.Marshal(map[string]json.RawMessage{k: value})
+		if err != nil {
+			return err
+		}
+
+		// a failing key still allocates pointers, so try it on a copy first
+		if json.Unmarshal(single, reflect.New(t).Interface()) != nil {
+			continue
+		}
+
+		err = json.Unmarshal(single, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fields returns timeseries data for influxdb
+func (c *Config) Fields() map[string]interface{} {
+	f := make(map[string]interface{})
+	if c.Battery != nil {
+		f["battery"] = *c.Battery
+	}
+	if c.On != nil {
+		f["on"] = *c.On
+	}
+	if c.Reachable != nil {
+		f["reachable"] = *c.Reachable
+	}
+	if c.Temperature != nil {
+		f["temperature"] = float64(*c.Temperature) / 100
+	}
+	if c.Heatsetpoint != nil {
+		f["heatsetpoint"] = float64(*c.Heatsetpoint) / 100
+	}
+	if c.Mode != nil {
+		f["mode"] = *c.Mode
+	}
+	if c.Offset != nil {
+		f["offset"] = float64(*c.Offset) / 100
+	}
+	return f
+}
+
 // ZHAHumidity represents a presure change
 type ZHAHumidity struct {
 	State
@@ -210,17 +291,67 @@ func (z *ZHAFire) Fields() map[string]interface{} {
 	}
 }
 
-// ZHASwitch represents a change from a button or switch
+// ZHASwitch represents a change from a button or switch, cubes and rotary
+// dimmers additionally report gestures, angles and xy
 type ZHASwitch struct {
 	State
-	Buttonevent int
+	Buttonevent   int
+	Gesture       *int
+	Angle         *int
+	XY            []float64
+	Eventduration *int
+}
+
+// ButtonActions names the action encoded in the last three digits of a buttonevent
+var ButtonActions = map[int]string{
+	0:  "initial_press",
+	1:  "hold",
+	2:  "short_release",
+	3:  "long_release",
+	4:  "double_press",
+	5:  "triple_press",
+	6:  "quadruple_press",
+	7:  "shake",
+	8:  "drop",
+	9:  "tilt",
+	10: "many_press",
+}
+
+// Button returns the button number and action code of the buttonevent,
+// deCONZ encodes them as button * 1000 + action
+func (z *ZHASwitch) Button() (int, int) {
+	return z.Buttonevent / 1000, z.Buttonevent % 1000
 }
 
 // Fields returns timeseries data for influxdb
 func (z *ZHASwitch) Fields() map[string]interface{} {
-	return map[string]interface{}{
-		"buttonevent": z.Buttonevent,
+	f := make(map[string]interface{})
+
+	// buttonevents start at 1000, rotary dimmers can report only an angle
+	if z.Buttonevent > 0 {
+		button, action := z.Button()
+		f["buttonevent"] = z.Buttonevent
+		f["button"] = button
+		f["action_code"] = action
+		if name, found := ButtonActions[action]; found {
+			f["action"] = name
+		}
+	}
+	if z.Gesture != nil {
+		f["gesture"] = *z.Gesture
+	}
+	if z.Angle != nil {
+		f["angle"] = *z.Angle
 	}
+	if len(z.XY) == 2 {
+		f["x"] = z.XY[0]
+		f["y"] = z.XY[1]
+	}
+	if z.Eventduration != nil {
+		f["eventduration"] = *z.Eventduration
+	}
+
+	return f
 }
 
 // Daylight represents a change in daylight
@@ -283,17 +414,33 @@ func (z *ZHALightLevel) Fields() map[string]interface{} {
 	}
 }
 
-// ZHAVibration represents a Vibration Sensor
+// ZHAVibration represents a Vibration Sensor, tiltangle, vibrationstrength
+// and orientation are only sent by some sensors
 type ZHAVibration struct {
 	State
-	Vibration bool
+	Vibration         bool
+	Tiltangle         *int
+	Vibrationstrength *int
+	Orientation       []int
 }
 
 // Fields returns timeseries data for influxdb
 func (z *ZHAVibration) Fields() map[string]interface{} {
-	return map[string]interface{}{
+	f := map[string]interface{}{
 		"vibration": z.Vibration,
 	}
+	if z.Tiltangle != nil {
+		f["tiltangle"] = *z.Tiltangle
+	}
+	if z.Vibrationstrength != nil {
+		f["vibrationstrength"] = *z.Vibrationstrength
+	}
+	if len(z.Orientation) == 3 {
+		f["orientation_x"] = z.Orientation[0]
+		f["orientation_y"] = z.Orientation[1]
+		f["orientation_z"] = z.Orientation[2]
+	}
+	return f
 }
 
 // ZHAVibration represents a Vibration Sensor
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"testing"
+	"time"
 )
 
 // examples from the xiaomi temp/hum/pressure sensor
@@ -52,7 +53,7 @@ func TestSmokeDetectorNoFireEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	smokeDetectorEvent, success := result.State.(ZHAFire)
+	smokeDetectorEvent, success := result.State.(*ZHAFire)
 	if !success {
 		t.Log("unable to type assert smoke detector event")
 		t.FailNow()
@@ -71,7 +72,7 @@ func TestFloodDetectorEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	floodEvent, success := result.State.(ZHAWater)
+	floodEvent, success := result.State.(*ZHAWater)
 	if !success {
 		t.Log("Unable to type assert floodevent")
 		t.FailNow()
@@ -91,7 +92,7 @@ func TestPressureEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	pressure, success := result.State.(ZHAPressure)
+	pressure, success := result.State.(*ZHAPressure)
 	if !success {
 		t.Log("Coudl not assert to pressureevent")
 		t.FailNow()
@@ -110,7 +111,7 @@ func TestTemperatureEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	temp, success := result.State.(ZHATemperature)
+	temp, success := result.State.(*ZHATemperature)
 	if !success {
 		t.Logf("Could not assert to temperature event")
 		t.FailNow()
@@ -129,7 +130,7 @@ func TestHumidityEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	humidity, success := result.State.(ZHAHumidity)
+	humidity, success := result.State.(*ZHAHumidity)
 	if !success {
 		t.Logf("unable assert humidity event")
 		t.FailNow()
@@ -148,7 +149,7 @@ func TestSwitchEvent(t *testing.T) {
 		t.FailNow()
 	}
 
-	s, success := result.State.(ZHASwitch)
+	s, success := result.State.(*ZHASwitch)
 	if !success {
 		t.Logf("unable assert switch event")
 		t.FailNow()
@@ -157,4 +158,414 @@ func TestSwitchEvent(t *testing.T) {
 	if s.Buttonevent != 1000 {
 		t.Fail()
 	}
+
+	fields := s.Fields()
+	if fields["button"] != 1 || fields["action_code"] != 0 || fields["action"] != "initial_press" {
+		t.Errorf("unexpected switch fields: %v", fields)
+	}
+}
+
+const cubeEventPayload = `{"e":"changed","id":"7","r":"sensors","state":{"buttonevent":3003,"gesture":3,"lastupdated":"2020-05-01T10:12:01"},"t":"event"}`
+const rotaryEventPayload = `{"e":"changed","id":"7","r":"sensors","state":{"angle":-4500,"eventduration":12,"lastupdated":"2020-05-01T10:12:02","xy":[0.25,0.5]},"t":"event"}`
+
+func TestSwitchGestureEvent(t *testing.T) {
+	result, err := decoder.Parse([]byte(cubeEventPayload))
+	if err != nil {
+		t.Fatalf("Could not parse cube event: %s", err)
+	}
+
+	fields := result.State.(*ZHASwitch).Fields()
+	if fields["button"] != 3 || fields["action"] != "long_release" || fields["gesture"] != 3 {
+		t.Errorf("unexpected cube fields: %v", fields)
+	}
+
+	result, err = decoder.Parse([]byte(rotaryEventPayload))
+	if err != nil {
+		t.Fatalf("Could not parse rotary event: %s", err)
+	}
+
+	fields = result.State.(*ZHASwitch).Fields()
+	if fields["angle"] != -4500 || fields["eventduration"] != 12 || fields["x"] != 0.25 || fields["y"] != 0.5 {
+		t.Errorf("unexpected rotary fields: %v", fields)
+	}
+	if _, found := fields["buttonevent"]; found {
+		t.Errorf("rotary event without buttonevent should not have one: %v", fields)
+	}
+}
+
+func TestStateTime(t *testing.T) {
+	result, err := decoder.Parse([]byte(temperatureEventPayload))
+	if err != nil {
+		t.Logf("Could not parse temperature: %s", err)
+		t.FailNow()
+	}
+
+	temp := result.State.(*ZHATemperature)
+	ts, err := temp.Time()
+	if err != nil {
+		t.Logf("unable to parse lastupdated: %s", err)
+		t.FailNow()
+	}
+
+	if !ts.Equal(time.Date(2018, 3, 8, 19, 35, 24, 0, time.UTC)) {
+		t.Fail()
+	}
+}
+
+func TestStateTimeMilliseconds(t *testing.T) {
+	s := State{Lastupdated: "2018-03-08T19:35:24.123"}
+	ts, err := s.Time()
+	if err != nil {
+		t.Logf("unable to parse lastupdated: %s", err)
+		t.FailNow()
+	}
+
+	if !ts.Equal(time.Date(2018, 3, 8, 19, 35, 24, 123000000, time.UTC)) {
+		t.Fail()
+	}
+}
+
+func TestStateTimeNone(t *testing.T) {
+	s := State{Lastupdated: "none"}
+	_, err := s.Time()
+	if err == nil {
+		t.Fail()
+	}
+}
+
+// xiaomi flood detector battery update
+const floodDetectorConfigEventPayload = `{"config":{"battery":100,"on":true,"reachable":true,"temperature":2000},"e":"changed","id":"6","r":"sensors","t":"event"}`
+
+func TestConfigEvent(t *testing.T) {
+	result, err := decoder.Parse([]byte(floodDetectorConfigEventPayload))
+	if err != nil {
+		t.Logf("Could not parse config event: %s", err)
+		t.FailNow()
+	}
+
+	if result.Config == nil {
+		t.Log("config was not parsed")
+		t.FailNow()
+	}
+
+	fields := result.Config.Fields()
+	if fields["battery"] != 100 || fields["reachable"] != true || fields["temperature"] != 20.0 {
+		t.Fail()
+	}
+}
+
+// mode should be a string and offset a number
+const oddConfigEventPayload = `{"config":{"battery":80,"mode":3,"offset":"none"},"e":"changed","id":"12","r":"sensors","t":"event"}`
+
+func TestConfigEventUnexpectedTypes(t *testing.T) {
+	result, err := decoder.Parse([]byte(oddConfigEventPayload))
+	if err != nil {
+		t.Fatalf("Could not parse config event with unexpected types: %s", err)
+	}
+
+	if result.Config.Battery == nil || *result.Config.Battery != 80 {
+		t.Errorf("battery was not parsed: %v", result.Config.Battery)
+	}
+	if result.Config.Mode != nil || result.Config.Offset != nil {
+		t.Errorf("keys of unexpected types should be ignored: %v %v", result.Config.Mode, result.Config.Offset)
+	}
+}
+
+func TestThermostatErrorcode(t *testing.T) {
+	for _, errorcode := range []interface{}{true, 3.0, "E1"} {
+		z := &ZHAThermostat{Errorcode: errorcode}
+		if _, ok := z.Fields()["errorcode"].(string); !ok {
+			t.Errorf("errorcode %v was not recorded as a string", errorcode)
+		}
+	}
+}
+
+// a sensor type deflux does not know about
+const unknownSensorEventPayload = `{"e":"changed","id":"8","r":"sensors","state":{"lastupdated":"2018-03-20T20:52:18","level":42,"ratio":0.5,"active":true,"mode":"auto","nested":{"depth":3}},"t":"event"}`
+
+func TestUnknownSensorEvent(t *testing.T) {
+	d := Decoder{TypeStore: &LookupImpl{Store: map[int]string{8: "ZHAUnknown"}}}
+	result, err := d.Parse([]byte(unknownSensorEventPayload))
+	if err != nil {
+		t.Logf("Could not parse unknown event: %s", err)
+		t.FailNow()
+	}
+
+	s, success := result.State.(*GenericState)
+	if !success {
+		t.Logf("unknown event was not decoded into a GenericState: %T", result.State)
+		t.FailNow()
+	}
+
+	fields := s.Fields()
+	if fields["level"] != 42.0 || fields["ratio"] != 0.5 || fields["active"] != true || fields["nested_depth"] != 3.0 {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+
+	if _, found := fields["mode"]; found {
+		t.Errorf("string values should not be fields: %v", fields)
+	}
+
+	if _, err := s.Time(); err != nil {
+		t.Errorf("unable to parse lastupdated: %s", err)
+	}
+}
+
+// Level is a state defined outside the registry
+type Level struct {
+	State
+	Level int
+}
+
+func TestDecoderRegister(t *testing.T) {
+	d := Decoder{TypeStore: &LookupImpl{Store: map[int]string{8: "ZHAUnknown"}}}
+	d.Register(func() interface{} { return &Level{} }, "ZHAUnknown", "CLIPUnknown")
+
+	result, err := d.Parse([]byte(unknownSensorEventPayload))
+	if err != nil {
+		t.Logf("Could not parse registered event: %s", err)
+		t.FailNow()
+	}
+
+	l, success := result.State.(*Level)
+	if !success || l.Level != 42 {
+		t.Errorf("registered state was not used: %T", result.State)
+	}
+
+	// the default registry should be unaffected
+	if _, found := DefaultRegistry["ZHAUnknown"]; found {
+		t.Errorf("registering with a decoder changed the default registry")
+	}
+}
+
+// smart plug energy and power meters
+const consumptionEventPayload = `{"e":"changed","id":"9","r":"sensors","state":{"consumption":12345,"lastupdated":"2020-03-12T10:01:02.345","power":120},"t":"event"}`
+const powerEventPayload = `{"e":"changed","id":"10","r":"sensors","state":{"current":520,"lastupdated":"2020-03-12T10:01:02.345","power":120,"voltage":231},"t":"event"}`
+
+func TestEnergyEvents(t *testing.T) {
+	d := Decoder{TypeStore: &LookupImpl{Store: map[int]string{9: "ZHAConsumption", 10: "ZHAPower"}}}
+
+	result, err := d.Parse([]byte(consumptionEventPayload))
+	if err != nil {
+		t.Logf("Could not parse consumption event: %s", err)
+		t.FailNow()
+	}
+
+	fields := result.State.(*ZHAConsumption).Fields()
+	if fields["consumption"] != int64(12345) || fields["consumption_kwh"] != 12.345 || fields["power"] != 120 {
+		t.Errorf("unexpected consumption fields: %v", fields)
+	}
+
+	result, err = d.Parse([]byte(powerEventPayload))
+	if err != nil {
+		t.Logf("Could not parse power event: %s", err)
+		t.FailNow()
+	}
+
+	fields = result.State.(*ZHAPower).Fields()
+	if fields["power"] != 120 || fields["voltage"] != 231 || fields["current"] != 0.52 {
+		t.Errorf("unexpected power fields: %v", fields)
+	}
+}
+
+// air quality sensors
+const airQualityEventPayload = `{"e":"changed","id":"13","r":"sensors","state":{"airquality":"moderate","airqualityppb":250,"lastupdated":"2021-11-02T07:15:00.100"},"t":"event"}`
+const carbonDioxideEventPayload = `{"e":"changed","id":"14","r":"sensors","state":{"lastupdated":"2021-11-02T07:15:00.100","measured_value":812},"t":"event"}`
+const particulateMatterEventPayload = `{"e":"changed","id":"15","r":"sensors","state":{"airquality":"good","lastupdated":"2021-11-02T07:15:00.100","measured_value":9},"t":"event"}`
+const formaldehydeEventPayload = `{"e":"changed","id":"16","r":"sensors","state":{"lastupdated":"2021-11-02T07:15:00.100","measured_value":21},"t":"event"}`
+
+func TestAirQualityEvents(t *testing.T) {
+	d := Decoder{TypeStore: &LookupImpl{Store: map[int]string{13: "ZHAAirQuality", 14: "ZHACarbonDioxide", 15: "ZHAParticulateMatter", 16: "ZHAFormaldehyde"}}}
+
+	result, err := d.Parse([]byte(airQualityEventPayload))
+	if err != nil {
+		t.Logf("Could not parse air quality event: %s", err)
+		t.FailNow()
+	}
+
+	fields := result.State.(*ZHAAirQuality).Fields()
+	if fields["airquality"] != "moderate" || fields["airquality_level"] != 3 || fields["airqualityppb"] != 250 {
+		t.Errorf("unexpected air quality fields: %v", fields)
+	}
+
+	result, err = d.Parse([]byte(carbonDioxideEventPayload))
+	if err != nil {
+		t.Logf("Could not parse carbon dioxide event: %s", err)
+		t.FailNow()
+	}
+
+	fields = result.State.(*ZHACarbonDioxide).Fields()
+	if fields["co2"] != 812 {
+		t.Errorf("unexpected carbon dioxide fields: %v", fields)
+	}
+
+	result, err = d.Parse([]byte(particulateMatterEventPayload))
+	if err != nil {
+		t.Logf("Could not parse particulate matter event: %s", err)
+		t.FailNow()
+	}
+
+	fields = result.State.(*ZHAParticulateMatter).Fields()
+	if fields["pm2_5"] != 9 || fields["airquality"] != "good" || fields["airquality_level"] != 2 {
+		t.Errorf("unexpected particulate matter fields: %v", fields)
+	}
+
+	result, err = d.Parse([]byte(formaldehydeEventPayload))
+	if err != nil {
+		t.Logf("Could not parse formaldehyde event: %s", err)
+		t.FailNow()
+	}
+
+	fields = result.State.(*ZHAFormaldehyde).Fields()
+	if fields["formaldehyde"] != 21 {
+		t.Errorf("unexpected formaldehyde fields: %v", fields)
+	}
+}
+
+const lightEventPayload = `{"e":"changed","id":"4","r":"lights","state":{"alert":null,"bri":180,"colormode":"ct","ct":366,"on":true,"reachable":true},"t":"event"}`
+const groupEventPayload = `{"e":"changed","id":"2","r":"groups","state":{"all_on":false,"any_on":true},"t":"event"}`
+
+func TestLightAndGroupEvents(t *testing.T) {
+	result, err := decoder.Parse([]byte(lightEventPayload))
+	if err != nil {
+		t.Logf("Could not parse light event: %s", err)
+		t.FailNow()
+	}
+
+	fields := result.State.(*LightState).Fields()
+	if fields["on"] != true || fields["bri"] != 180 || fields["ct"] != 366 || fields["reachable"] != true {
+		t.Errorf("unexpected light fields: %v", fields)
+	}
+	if _, found := fields["hue"]; found {
+		t.Errorf("fields not sent should not be set: %v", fields)
+	}
+
+	result, err = decoder.Parse([]byte(groupEventPayload))
+	if err != nil {
+		t.Logf("Could not parse group event: %s", err)
+		t.FailNow()
+	}
+
+	fields = result.State.(*GroupState).Fields()
+	if fields["all_on"] != false || fields["any_on"] != true {
+		t.Errorf("unexpected group fields: %v", fields)
+	}
+}
+
+const sceneCalledPayload = `{"e":"scene-called","gid":"3","r":"scenes","scid":"2","t":"event"}`
+
+func TestSceneCalledEvent(t *testing.T) {
+	result, err := decoder.Parse([]byte(sceneCalledPayload))
+	if err != nil {
+		t.Fatalf("Could not parse scene event: %s", err)
+	}
+
+	if result.Event != "scene-called" || result.GroupID != 3 || result.SceneID != 2 {
+		t.Errorf("unexpected scene event: %+v", result)
+	}
+}
+
+const doorLockEventPayload = `{"e":"changed","id":"20","r":"sensors","state":{"lastupdated":"2022-02-11T17:02:31.512","lockstate":"locked"},"t":"event"}`
+const alarmEventPayload = `{"e":"changed","id":"22","r":"sensors","state":{"alarm":true,"lastupdated":"2022-02-11T17:02:35.250","lowbattery":false,"tampered":false},"t":"event"}`
+const keypadEventPayload = `{"e":"changed","id":"21","r":"sensors","state":{"action":"arm_away","lastupdated":"2022-02-11T17:02:33.004","panel":"exit_delay","seconds_remaining":30},"t":"event"}`
+
+func TestSecurityEvents(t *testing.T) {
+	d := Decoder{TypeStore: &LookupImpl{Store: map[int]string{20: "ZHADoorLock", 21: "ZHAAncillaryControl", 22: "ZHAAlarm"}}}
+
+	result, err := d.Parse([]byte(doorLockEventPayload))
+	if err != nil {
+		t.Fatalf("Could not parse door lock event: %s", err)
+	}
+
+	lock := result.State.(*ZHADoorLock)
+	fields := lock.Fields()
+	if fields["lockstate"] != "locked" || fields["locked"] != true {
+		t.Errorf("unexpected door lock fields: %v", fields)
+	}
+
+	ts, err := lock.Time()
+	if err != nil || ts.Nanosecond() != 512000000 {
+		t.Errorf("door lock time should keep milliseconds, got %s: %v", ts, err)
+	}
+
+	result, err = d.Parse([]byte(keypadEventPayload))
+	if err != nil {
+		t.Fatalf("Could not parse keypad event: %s", err)
+	}
+
+	fields = result.State.(*ZHAAncillaryControl).Fields()
+	if fields["action"] != "arm_away" || fields["panel"] != "exit_delay" || fields["seconds_remaining"] != 30 {
+		t.Errorf("unexpected keypad fields: %v", fields)
+	}
+	if _, found := fields["tampered"]; found {
+		t.Errorf("fields not sent should not be set: %v", fields)
+	}
+
+	result, err = d.Parse([]byte(alarmEventPayload))
+	if err != nil {
+		t.Fatalf("Could not parse alarm event: %s", err)
+	}
+
+	fields = result.State.(*ZHAAlarm).Fields()
+	if fields["alarm"] != true || fields["lowbattery"] != false || fields["tampered"] != false {
+		t.Errorf("unexpected alarm fields: %v", fields)
+	}
+}
+
+const genericStatusEventPayload = `{"e":"changed","id":"30","r":"sensors","state":{"lastupdated":"2022-03-01T06:30:00","status":2},"t":"event"}`
+const clipTemperatureEventPayload = `{"e":"changed","id":"31","r":"sensors","state":{"lastupdated":"2022-03-01T06:30:00","temperature":2150},"t":"event"}`
+
+func TestCLIPEvents(t *testing.T) {
+	d := Decoder{TypeStore: &LookupImpl{Store: map[int]string{30: "CLIPGenericStatus", 31: "CLIPTemperature"}}}
+
+	result, err := d.Parse([]byte(genericStatusEventPayload))
+	if err != nil {
+		t.Fatalf("Could not parse generic status event: %s", err)
+	}
+
+	fields := result.State.(*CLIPGenericStatus).Fields()
+	if fields["status"] != 2 {
+		t.Errorf("unexpected generic status fields: %v", fields)
+	}
+
+	result, err = d.Parse([]byte(clipTemperatureEventPayload))
+	if err != nil {
+		t.Fatalf("Could not parse clip temperature event: %s", err)
+	}
+
+	// fields should match those of a ZHATemperature
+	fields = result.State.(*ZHATemperature).Fields()
+	if fields["temperature"] != 21.5 {
+		t.Errorf("unexpected clip temperature fields: %v", fields)
+	}
+}
+
+const vibrationEventPayload = `{"e":"changed","id":"40","r":"sensors","state":{"lastupdated":"2022-04-02T09:10:11","orientation":[1,-2,88],"tiltangle":4,"vibration":true,"vibrationstrength":112},"t":"event"}`
+const moistureEventPayload = `{"e":"changed","id":"41","r":"sensors","state":{"lastupdated":"2022-04-02T09:10:11","moisture":37},"t":"event"}`
+
+func TestVibrationAndMoistureEvents(t *testing.T) {
+	d := Decoder{TypeStore: &LookupImpl{Store: map[int]string{40: "ZHAVibration", 41: "ZHAMoisture"}}}
+
+	result, err := d.Parse([]byte(vibrationEventPayload))
+	if err != nil {
+		t.Fatalf("Could not parse vibration event: %s", err)
+	}
+
+	fields := result.State.(*ZHAVibration).Fields()
+	if fields["vibration"] != true || fields["tiltangle"] != 4 || fields["vibrationstrength"] != 112 {
+		t.Errorf("unexpected vibration fields: %v", fields)
+	}
+	if fields["orientation_x"] != 1 || fields["orientation_y"] != -2 || fields["orientation_z"] != 88 {
+		t.Errorf("unexpected orientation fields: %v", fields)
+	}
+
+	result, err = d.Parse([]byte(moistureEventPayload))
+	if err != nil {
+		t.Fatalf("Could not parse moisture event: %s", err)
+	}
+
+	fields = result.State.(*ZHAMoisture).Fields()
+	if fields["moisture"] != 37 {
+		t.Errorf("unexpected moisture fields: %v", fields)
+	}
 }
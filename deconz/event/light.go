@@ -0,0 +1,59 @@
+package event
+
+// LightState represents a change of a light, deCONZ does not always send
+// the full state so fields not sent are nil
+type LightState struct {
+	On        *bool
+	Bri       *int
+	CT        *int
+	Hue       *int
+	Sat       *int
+	XY        []float64
+	Reachable *bool
+}
+
+// Fields returns timeseries data for influxdb
+func (l *LightState) Fields() map[string]interface{} {
+	f := make(map[string]interface{})
+	if l.On != nil {
+		f["on"] = *l.On
+	}
+	if l.Bri != nil {
+		f["bri"] = *l.Bri
+	}
+	if l.CT != nil {
+		f["ct"] = *l.CT
+	}
+	if l.Hue != nil {
+		f["hue"] = *l.Hue
+	}
+	if l.Sat != nil {
+		f["sat"] = *l.Sat
+	}
+	if len(l.XY) == 2 {
+		f["x"] = l.XY[0]
+		f["y"] = l.XY[1]
+	}
+	if l.Reachable != nil {
+		f["reachable"] = *l.Reachable
+	}
+	return f
+}
+
+// GroupState represents a change of a group
+type GroupState struct {
+	AllOn *bool `json:"all_on"`
+	AnyOn *bool `json:"any_on"`
+}
+
+// Fields returns timeseries data for influxdb
+func (g *GroupState) Fields() map[string]interface{} {
+	f := make(map[string]interface{})
+	if g.AllOn != nil {
+		f["all_on"] = *g.AllOn
+	}
+	if g.AnyOn != nil {
+		f["any_on"] = *g.AnyOn
+	}
+	return f
+}
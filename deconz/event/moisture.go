@@ -0,0 +1,19 @@
+package event
+
+func init() {
+	Register(func() interface{} { return &ZHAMoisture{} }, "ZHAMoisture")
+}
+
+// ZHAMoisture represents a change from a soil moisture sensor, moisture is
+// in percent
+type ZHAMoisture struct {
+	State
+	Moisture int
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAMoisture) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"moisture": z.Moisture,
+	}
+}
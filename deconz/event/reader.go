@@ -1,9 +1,13 @@
 package event
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -12,18 +16,32 @@ import (
 type Reader struct {
 	WebsocketAddr string
 	TypeStore     TypeLookuper
-	decoder       *Decoder
-	conn          *websocket.Conn
+	// Registry decodes states, DefaultRegistry is used if it is nil
+	Registry Registry
+	// Keepalive is how often the connection is pinged, the connection is
+	// considered dead when nothing, not even a pong, has been received for
+	// twice as long. Pinging is disabled when it is zero
+	Keepalive time.Duration
+	// TLSConfig is used when dialing wss:// addresses
+	TLSConfig *tls.Config
+	// Header is sent with the websocket handshake
+	Header  http.Header
+	decoder *Decoder
+
+	// mu guards conn as Close may be called while ReadEvent blocks,
+	// stop stops pinging conn
+	mu   sync.Mutex
+	conn *websocket.Conn
+	stop chan struct{}
 }
 
-
 type EventError interface {
 	error
 	Recoverable() bool
 }
 
 type EventErrorImpl struct {
-	errStr string
+	errStr      string
 	recoverable bool
 }
 
@@ -43,25 +61,76 @@ func (r *Reader) Dial() error {
 	}
 
 	// create a decoder with the typestore
-	r.decoder = &Decoder{TypeStore: r.TypeStore}
+	r.decoder = &Decoder{TypeStore: r.TypeStore, Registry: r.Registry}
+
+	// a previous connection is left behind when dialing again after it failed
+	r.Close()
 
 	// connect
-	var err error
-	r.conn, _, err = websocket.DefaultDialer.Dial(r.WebsocketAddr, nil)
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = r.TLSConfig
+	conn, _, err := dialer.Dial(r.WebsocketAddr, r.Header)
 	if err != nil {
 		return fmt.Errorf("unable to dail %s: %s", r.WebsocketAddr, err)
 	}
+
+	stop := make(chan struct{})
+	if r.Keepalive > 0 {
+		conn.SetReadDeadline(time.Now().Add(2 * r.Keepalive))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * r.Keepalive))
+		})
+		go r.ping(conn, stop)
+	}
+
+	r.mu.Lock()
+	r.conn = conn
+	r.stop = stop
+	r.mu.Unlock()
+
 	return nil
 }
 
+// ping pings conn every Keepalive until stop is closed, a half open connection
+// is noticed by ReadEvent when no pongs arrive before the read deadline
+func (r *Reader) ping(conn *websocket.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(r.Keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(r.Keepalive))
+			if err != nil {
+				// the connection is gone, ReadEvent will tell
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
 // ReadEvent reads, parses and returns the next event
 func (r *Reader) ReadEvent() (*Event, error) {
 
-	_, message, err := r.conn.ReadMessage()
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+
+	if conn == nil {
+		return nil, errors.New("event read error: not connected")
+	}
+
+	_, message, err := conn.ReadMessage()
 	if err != nil {
 		return nil, fmt.Errorf("event read error: %s", err)
 	}
 
+	if r.Keepalive > 0 {
+		conn.SetReadDeadline(time.Now().Add(2 * r.Keepalive))
+	}
+
 	log.Printf("recv: %s", message)
 
 	e, err := r.decoder.Parse(message)
@@ -72,7 +141,25 @@ func (r *Reader) ReadEvent() (*Event, error) {
 	return e, nil
 }
 
-// Close closes the connection to deconz
+// Close closes the connection to deconz, it is safe to call while
+// ReadEvent is blocking which makes ReadEvent return an error
 func (r *Reader) Close() error {
-	return r.conn.Close()
+	r.mu.Lock()
+	conn := r.conn
+	r.conn = nil
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	r.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	// tell deconz we are leaving before closing the connection
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+
+	return conn.Close()
 }
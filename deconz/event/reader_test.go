@@ -0,0 +1,55 @@
+package event
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReaderKeepalive(t *testing.T) {
+	// the server never reads, which means it never answers pings, just
+	// like a gateway on the other side of a half open connection
+	upgrader := websocket.Upgrader{}
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	r := &Reader{
+		WebsocketAddr: "ws" + strings.TrimPrefix(server.URL, "http"),
+		TypeStore:     &LookupImpl{},
+		Keepalive:     20 * time.Millisecond,
+	}
+
+	err := r.Dial()
+	if err != nil {
+		t.Fatalf("unable to dial: %s", err)
+	}
+	defer r.Close()
+
+	failed := make(chan error)
+	go func() {
+		_, err := r.ReadEvent()
+		failed <- err
+	}()
+
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Errorf("expected ReadEvent to fail without pongs")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("ReadEvent did not notice the missing pongs")
+	}
+}
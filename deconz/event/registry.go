@@ -0,0 +1,129 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Registry maps deCONZ sensor types to constructors of their state, it is
+// not safe to register types while decoding
+type Registry map[string]func() interface{}
+
+// DefaultRegistry holds the states of every sensor type this package knows about
+var DefaultRegistry = Registry{}
+
+func init() {
+	Register(func() interface{} { return &ZHAFire{} }, "ZHAFire")
+	Register(func() interface{} { return &ZHATemperature{} }, "ZHATemperature")
+	Register(func() interface{} { return &ZHAPressure{} }, "ZHAPressure")
+	Register(func() interface{} { return &ZHAHumidity{} }, "ZHAHumidity")
+	Register(func() interface{} { return &ZHAWater{} }, "ZHAWater")
+	Register(func() interface{} { return &ZHASwitch{} }, "ZHASwitch")
+	Register(func() interface{} { return &Daylight{} }, "Daylight")
+	Register(func() interface{} { return &ZHAPresence{} }, "ZHAPresence")
+	Register(func() interface{} { return &CLIPPresence{} }, "CLIPPresence")
+	Register(func() interface{} { return &ZHALightLevel{} }, "ZHALightLevel")
+	Register(func() interface{} { return &ZHAVibration{} }, "ZHAVibration")
+	Register(func() interface{} { return &ZHAOpenClose{} }, "ZHAOpenClose")
+	Register(func() interface{} { return &ZHACarbonMonoxide{} }, "ZHACarbonMonoxide")
+}
+
+// Register registers a state constructor for one or more deCONZ types in DefaultRegistry
+func Register(newState func() interface{}, types ...string) {
+	DefaultRegistry.Register(newState, types...)
+}
+
+// Register registers a state constructor for one or more deCONZ types
+func (r Registry) Register(newState func() interface{}, types ...string) {
+	for _, t := range types {
+		r[t] = newState
+	}
+}
+
+// Copy returns a copy of the registry which can be extended on its own
+func (r Registry) Copy() Registry {
+	c := make(Registry, len(r))
+	for t, newState := range r {
+		c[t] = newState
+	}
+	return c
+}
+
+// Decode unmarshals the state of a sensor of type t, states of unknown
+// types are decoded into a GenericState
+func (r Registry) Decode(t string, raw json.RawMessage) (interface{}, error) {
+	newState, found := r[t]
+	if !found {
+		g, err := decodeGenericState(raw)
+		if err != nil {
+			return nil, fmt.Errorf("unable to unmarshal %s event state: %s", t, err)
+		}
+		return g, nil
+	}
+
+	s := newState()
+	err := json.Unmarshal(raw, s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %s event state: %s", t, err)
+	}
+
+	return s, nil
+}
+
+// GenericState is the state of a sensor type without a registered state,
+// Values holds every numeric and boolean value of the state. Nested values
+// are flattened into keys joined by underscores and numbers are always
+// float64, a value going from 20 to 20.5 must not change its field type
+type GenericState struct {
+	State
+	Values map[string]interface{}
+}
+
+// Fields returns timeseries data for influxdb
+func (g *GenericState) Fields() map[string]interface{} {
+	return g.Values
+}
+
+func decodeGenericState(raw json.RawMessage) (*GenericState, error) {
+	g := &GenericState{Values: make(map[string]interface{})}
+
+	err := json.Unmarshal(raw, &g.State)
+	if err != nil {
+		return nil, err
+	}
+
+	var v map[string]interface{}
+	err = json.Unmarshal(raw, &v)
+	if err != nil {
+		return nil, err
+	}
+
+	flatten(g.Values, "", v)
+
+	return g, nil
+}
+
+// flatten adds every numeric and boolean value of v to values
+func flatten(values map[string]interface{}, prefix string, v map[string]interface{}) {
+	for k, value := range v {
+		key := k
+		if prefix != "" {
+			key = fmt.Sprintf("%s_%s", prefix, k)
+		}
+
+		switch t := value.(type) {
+		case bool:
+			values[key] = t
+		case float64:
+			values[key] = t
+		case map[string]interface{}:
+			flatten(values, key, t)
+		case []interface{}:
+			m := make(map[string]interface{}, len(t))
+			for i, item := range t {
+				m[fmt.Sprint(i)] = item
+			}
+			flatten(values, key, m)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package event
+
+func init() {
+	Register(func() interface{} { return &ZHADoorLock{} }, "ZHADoorLock")
+	Register(func() interface{} { return &ZHAAlarm{} }, "ZHAAlarm")
+	Register(func() interface{} { return &ZHAAncillaryControl{} }, "ZHAAncillaryControl")
+}
+
+// ZHADoorLock represents a change from a door lock, lockstate is one of
+// locked, unlocked, not fully locked or undefined
+type ZHADoorLock struct {
+	State
+	Lockstate string
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHADoorLock) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"lockstate": z.Lockstate,
+		"locked":    z.Lockstate == "locked",
+	}
+}
+
+// ZHAAlarm represents a change from an alarm device such as a siren
+type ZHAAlarm struct {
+	State
+	Alarm      bool
+	Lowbattery bool
+	Tampered   bool
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAAlarm) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"alarm":      z.Alarm,
+		"lowbattery": z.Lowbattery,
+		"tampered":   z.Tampered,
+	}
+}
+
+// ZHAAncillaryControl represents a change from a keypad, action is what was
+// entered on the keypad, e.g. arm_away or disarm, and panel is the state the
+// keypad shows, e.g. armed_away or exit_delay. deCONZ only sends what changed
+type ZHAAncillaryControl struct {
+	State
+	Action           string
+	Panel            string
+	SecondsRemaining *int `json:"seconds_remaining"`
+	Tampered         *bool
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAAncillaryControl) Fields() map[string]interface{} {
+	f := make(map[string]interface{})
+	if z.Action != "" {
+		f["action"] = z.Action
+	}
+	if z.Panel != "" {
+		f["panel"] = z.Panel
+	}
+	if z.SecondsRemaining != nil {
+		f["seconds_remaining"] = *z.SecondsRemaining
+	}
+	if z.Tampered != nil {
+		f["tampered"] = *z.Tampered
+	}
+	return f
+}
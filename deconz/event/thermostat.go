@@ -0,0 +1,67 @@
+package event
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+func init() {
+	Register(func() interface{} { return &ZHAThermostat{} }, "ZHAThermostat")
+}
+
+// ZHAThermostat represents a change from a thermostat, e.g. a radiator valve
+type ZHAThermostat struct {
+	State
+	On          bool
+	Temperature int
+	Valve       int
+	// Errorcode is reported as a bool, number or string by different devices,
+	// it is recorded as a string to keep the field type the same
+	Errorcode interface{}
+	// Config is not part of the state, it must be applied from the sensor
+	// config using ApplyConfig
+	Config ThermostatConfig `json:"-"`
+}
+
+// ThermostatConfig is the part of the thermostat config we record along with its state
+type ThermostatConfig struct {
+	Heatsetpoint *int
+	Mode         *string
+	Offset       *int
+}
+
+// ApplyConfig unmarshals the thermostat config from a sensor config, keys of
+// an unexpected type are ignored
+func (z *ZHAThermostat) ApplyConfig(raw json.RawMessage) error {
+	return UnmarshalLenient(raw, &z.Config)
+}
+
+// Fields returns timeseries data for influxdb
+func (z *ZHAThermostat) Fields() map[string]interface{} {
+	f := map[string]interface{}{
+		"on":          z.On,
+		"temperature": float64(z.Temperature) / 100,
+		"valve":       z.Valve,
+	}
+
+	switch e := z.Errorcode.(type) {
+	case bool:
+		f["errorcode"] = strconv.FormatBool(e)
+	case float64:
+		f["errorcode"] = strconv.FormatFloat(e, 'f', -1, 64)
+	case string:
+		f["errorcode"] = e
+	}
+
+	if z.Config.Heatsetpoint != nil {
+		f["heatsetpoint"] = float64(*z.Config.Heatsetpoint) / 100
+	}
+	if z.Config.Mode != nil {
+		f["mode"] = *z.Config.Mode
+	}
+	if z.Config.Offset != nil {
+		f["offset"] = float64(*z.Config.Offset) / 100
+	}
+
+	return f
+}
@@ -0,0 +1,41 @@
+package deconz
+
+// Lights is a map of lights indexed by their id
+type Lights map[int]Light
+
+// Light is a deCONZ light, only fields needed for describing the light to
+// sinks are implemented
+type Light struct {
+	Type             string
+	Name             string
+	ManufacturerName string
+	ModelID          string
+	UniqueID         string
+}
+
+// Groups is a map of groups indexed by their id
+type Groups map[int]Group
+
+// Group is a deCONZ group and the scenes stored in it
+type Group struct {
+	Type   string
+	Name   string
+	Scenes []Scene
+}
+
+// Scene is a deCONZ scene, scene ids are only unique within their group
+type Scene struct {
+	ID   int `json:"id,string"`
+	Name string
+}
+
+// Scene returns the scene with id i
+func (g *Group) Scene(i int) (*Scene, bool) {
+	for _, s := range g.Scenes {
+		if s.ID == i {
+			return &s, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,119 @@
+package deconz
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fasmide/deflux/deconz/event"
+)
+
+// ResourceEvent is an event from any deCONZ resource, sensors, lights or groups,
+// which can be written to sinks
+type ResourceEvent interface {
+	Measurements() ([]Measurement, error)
+}
+
+// LightEvent is a light and a event embedded
+type LightEvent struct {
+	*Light
+	*event.Event
+	// Received is when the event was read from deCONZ
+	Received time.Time
+	// Gateway is the name of the gateway the event was read from
+	Gateway string
+}
+
+// Timeseries returns tags and fields of the light state
+func (l *LightEvent) Timeseries() (map[string]string, map[string]interface{}, error) {
+	return resourceTimeseries(l.Event, l.Light.Name, l.Light.Type, l.Gateway)
+}
+
+// Measurements returns the light state as a deflux_light measurement, lights
+// carry no lastupdated so the time the event was received is used
+func (l *LightEvent) Measurements() ([]Measurement, error) {
+	tags, fields, err := l.Timeseries()
+	if err != nil {
+		return nil, err
+	}
+
+	return []Measurement{{Name: "deflux_light", Tags: tags, Fields: fields, Time: receivedOrNow(l.Received)}}, nil
+}
+
+// GroupEvent is a group and a event embedded
+type GroupEvent struct {
+	*Group
+	*event.Event
+	// Received is when the event was read from deCONZ
+	Received time.Time
+	// Gateway is the name of the gateway the event was read from
+	Gateway string
+}
+
+// Timeseries returns tags and fields of the group state
+func (g *GroupEvent) Timeseries() (map[string]string, map[string]interface{}, error) {
+	return resourceTimeseries(g.Event, g.Group.Name, g.Group.Type, g.Gateway)
+}
+
+// Measurements returns the group state as a deflux_group measurement using
+// the time the event was received
+func (g *GroupEvent) Measurements() ([]Measurement, error) {
+	tags, fields, err := g.Timeseries()
+	if err != nil {
+		return nil, err
+	}
+
+	return []Measurement{{Name: "deflux_group", Tags: tags, Fields: fields, Time: receivedOrNow(g.Received)}}, nil
+}
+
+// resourceTimeseries returns tags and fields of a light or group event
+func resourceTimeseries(e *event.Event, name, typ, gateway string) (map[string]string, map[string]interface{}, error) {
+	f, ok := e.State.(fielder)
+	if !ok {
+		return nil, nil, fmt.Errorf("this event (%T:%s) has no time series data", e.State, name)
+	}
+
+	fields := f.Fields()
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("this event (%T:%s) has no time series data", e.State, name)
+	}
+
+	tags := map[string]string{"name": name, "type": typ, "id": strconv.Itoa(e.ID)}
+	return gatewayTag(tags, gateway), fields, nil
+}
+
+// receivedOrNow returns received, or now for events that were never received
+func receivedOrNow(received time.Time) time.Time {
+	if received.IsZero() {
+		return time.Now()
+	}
+
+	return received
+}
+
+// SceneEvent is a scene being called in a group
+type SceneEvent struct {
+	*Group
+	*event.Event
+	Scene *Scene
+	// Received is when the event was read from deCONZ
+	Received time.Time
+	// Gateway is the name of the gateway the event was read from
+	Gateway string
+}
+
+// Measurements returns the scene call as a deflux_scene annotation tagged
+// with group and scene names
+func (s *SceneEvent) Measurements() ([]Measurement, error) {
+	tags := gatewayTag(map[string]string{
+		"group":    s.Group.Name,
+		"group_id": strconv.Itoa(s.GroupID),
+		"scene":    s.Scene.Name,
+		"scene_id": strconv.Itoa(s.SceneID),
+	}, s.Gateway)
+	fields := map[string]interface{}{
+		"text": fmt.Sprintf("%s scene activated in %s", s.Scene.Name, s.Group.Name),
+	}
+
+	return []Measurement{{Name: "deflux_scene", Tags: tags, Fields: fields, Time: receivedOrNow(s.Received)}}, nil
+}
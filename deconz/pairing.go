@@ -37,19 +37,46 @@ func Pair(u url.URL) (APIKey, error) {
 	// to pair we must send a POST request to "/api" containing a pairRequest
 	u.Path = "/api"
 
+	c := Config{Addr: u.String()}
+	return c.Pair()
+}
+
+// Pair tries to pair with deconz at c.Addr, using the TLS and headers of c,
+// and returns a pairing with an API key. The api is assumed to be at /api if
+// c.Addr has no path, behind a reverse proxy it could be anywhere
+func (c *Config) Pair() (APIKey, error) {
+	u, err := url.Parse(c.Addr)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse address: %s", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/api"
+	}
+
 	pr := pairRequest{
 		DeviceType: "Deflux",
 	}
 
 	var buff bytes.Buffer
 	enc := json.NewEncoder(&buff)
-	err := enc.Encode(pr)
+	err = enc.Encode(pr)
 	if err != nil {
 		return "", fmt.Errorf("unable to marshal pair request: %s", err)
 	}
 
 	// send POST request and read body
-	response, err := http.Post(u.String(), "application/json", &buff)
+	client, err := c.client()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := c.request(http.MethodPost, u.String(), &buff)
+	if err != nil {
+		return "", fmt.Errorf("unable to create post request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("unable to send post request: %s", err)
 	}
@@ -0,0 +1,311 @@
+package deconz
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fasmide/deflux/deconz/event"
+)
+
+// DefaultPollInterval is how often sensors are polled by default
+const DefaultPollInterval = 10 * time.Second
+
+// PollConfig configures polling sensors from the REST API, for deployments
+// where the websocket cannot be reached
+type PollConfig struct {
+	// Interval is how often sensors are polled, it defaults to 10s
+	Interval time.Duration `yaml:",omitempty"`
+	// Always polls sensors instead of reading events from the websocket
+	Always bool `yaml:",omitempty"`
+	// FallbackAfter is how many times in a row the websocket may fail to
+	// dial before polling instead, polling is never fallen back to when it is 0
+	FallbackAfter int `yaml:",omitempty"`
+}
+
+// Poller is an EventReader which polls sensors and yields a changed event
+// for every sensor whose lastupdated, name or config has changed since the
+// last poll. The events are identical to the ones read from the websocket.
+// Lights, groups and scenes are not polled
+type Poller struct {
+	SensorGetter
+	Interval time.Duration
+	// Registry decodes states, DefaultRegistry is used if it is nil
+	Registry event.Registry
+
+	// mu guards stop as Close may be called while ReadEvent blocks
+	mu   sync.Mutex
+	stop chan struct{}
+
+	// last, known and pending are only used by Dial and ReadEvent
+	last    map[int]time.Time
+	known   map[int]Sensor
+	pending []*event.Event
+}
+
+// Dial polls sensors to find out when they were last updated, only
+// updates after that are read by ReadEvent
+func (p *Poller) Dial() error {
+	p.Close()
+
+	p.last = make(map[int]time.Time)
+	p.known = make(map[int]Sensor)
+	p.pending = nil
+	_, err := p.poll()
+	if err != nil {
+		return fmt.Errorf("unable to poll sensors: %s", err)
+	}
+
+	p.mu.Lock()
+	p.stop = make(chan struct{})
+	p.mu.Unlock()
+
+	return nil
+}
+
+// ReadEvent returns the next changed sensor, polling until there is one
+func (p *Poller) ReadEvent() (*event.Event, error) {
+	p.mu.Lock()
+	stop := p.stop
+	p.mu.Unlock()
+
+	if stop == nil {
+		return nil, errors.New("event read error: not connected")
+	}
+
+	interval := p.Interval
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+
+	for len(p.pending) == 0 {
+		t := time.NewTimer(interval)
+		select {
+		case <-t.C:
+		case <-stop:
+			t.Stop()
+			return nil, errors.New("event read error: poller closed")
+		}
+
+		pending, err := p.poll()
+		if err != nil {
+			return nil, fmt.Errorf("event read error: %s", err)
+		}
+		p.pending = pending
+	}
+
+	e := p.pending[0]
+	p.pending = p.pending[1:]
+
+	return e, nil
+}
+
+// Close stops polling, it is safe to call while ReadEvent is blocking which
+// makes ReadEvent return an error
+func (p *Poller) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+
+	return nil
+}
+
+// poll returns events for sensors updated since the last poll, ordered by id
+func (p *Poller) poll() ([]*event.Event, error) {
+	sensors, err := p.Sensors()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(*sensors))
+	for id := range *sensors {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	decoder := &event.Decoder{TypeStore: sensors, Registry: p.Registry}
+
+	var events []*event.Event
+	for _, id := range ids {
+		// deCONZ sends renames and config changes as their own events
+		e, err := p.changedEvent(decoder, id, (*sensors)[id])
+		if err != nil {
+			log.Printf("Unable to decode config of sensor %d: %s", id, err)
+		}
+		if e != nil {
+			events = append(events, e)
+		}
+
+		e, updated, err := stateEvent(decoder, id, (*sensors)[id])
+		if err != nil {
+			continue
+		}
+
+		if updated.After(p.last[id]) {
+			p.last[id] = updated
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
+
+// changedEvent returns the changed event deCONZ would have sent over the
+// websocket when s was renamed or its config changed since the last poll,
+// only changed config is included. It returns nil if nothing changed
+func (p *Poller) changedEvent(d *event.Decoder, id int, s Sensor) (*event.Event, error) {
+	last, known := p.known[id]
+	p.known[id] = s
+	if !known {
+		return nil, nil
+	}
+
+	msg := map[string]interface{}{
+		"t":  "event",
+		"e":  "changed",
+		"r":  "sensors",
+		"id": strconv.Itoa(id),
+	}
+
+	if s.Name != last.Name {
+		msg["name"] = s.Name
+	}
+
+	config := make(map[string]interface{})
+	for k, v := range s.Config {
+		if !reflect.DeepEqual(last.Config[k], v) {
+			config[k] = v
+		}
+	}
+	if len(config) > 0 {
+		msg["config"] = config
+	}
+
+	if s.Name == last.Name && len(config) == 0 {
+		return nil, nil
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.Parse(payload)
+}
+
+// stateEvent decodes the state of a fetched sensor into the changed event
+// deCONZ would have sent over the websocket, together with its lastupdated
+func stateEvent(d *event.Decoder, id int, s Sensor) (*event.Event, time.Time, error) {
+	if len(s.State) == 0 {
+		return nil, time.Time{}, errors.New("sensor has no state")
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"t":     "event",
+		"e":     "changed",
+		"r":     "sensors",
+		"id":    strconv.Itoa(id),
+		"state": s.State,
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	e, err := d.Parse(payload)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	t, ok := e.State.(timer)
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("%T has no lastupdated", e.State)
+	}
+
+	updated, err := t.Time()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return e, updated, nil
+}
+
+// fallbackReader reads events from primary, falling back to polling once
+// primary has failed to dial too many times in a row. It never switches back
+type fallbackReader struct {
+	primary  EventReader
+	fallback EventReader
+	after    int
+
+	// mu guards current as Close may be called while ReadEvent blocks
+	mu       sync.Mutex
+	current  EventReader
+	failures int
+}
+
+// Dial dials primary until it has failed too many times, then fallback
+func (f *fallbackReader) Dial() error {
+	f.mu.Lock()
+	current := f.current
+	f.mu.Unlock()
+
+	if current == f.fallback {
+		return f.fallback.Dial()
+	}
+
+	err := f.primary.Dial()
+	if err == nil {
+		f.failures = 0
+		f.setCurrent(f.primary)
+		return nil
+	}
+
+	f.failures++
+	if f.failures < f.after {
+		return err
+	}
+
+	log.Printf("Unable to dial deCONZ %d times in a row, polling sensors instead: %s", f.failures, err)
+	f.setCurrent(f.fallback)
+
+	return f.fallback.Dial()
+}
+
+// ReadEvent reads from whichever reader was dialed
+func (f *fallbackReader) ReadEvent() (*event.Event, error) {
+	f.mu.Lock()
+	current := f.current
+	f.mu.Unlock()
+
+	if current == nil {
+		return nil, errors.New("event read error: not connected")
+	}
+
+	return current.ReadEvent()
+}
+
+// Close closes both readers
+func (f *fallbackReader) Close() error {
+	err := f.primary.Close()
+	if ferr := f.fallback.Close(); err == nil {
+		err = ferr
+	}
+
+	return err
+}
+
+func (f *fallbackReader) setCurrent(r EventReader) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.current = r
+}
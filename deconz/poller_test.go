@@ -0,0 +1,155 @@
+package deconz
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fasmide/deflux/deconz/event"
+)
+
+// pollGetter returns the next set of sensors on every call, repeating the last
+type pollGetter struct {
+	mu    sync.Mutex
+	polls []Sensors
+}
+
+func (p *pollGetter) Sensors() (*Sensors, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.polls[0]
+	if len(p.polls) > 1 {
+		p.polls = p.polls[1:]
+	}
+	return &s, nil
+}
+
+func temperatureSensor(state string) Sensor {
+	return Sensor{Type: "ZHATemperature", Name: "Attic", State: json.RawMessage(state)}
+}
+
+func TestPoller(t *testing.T) {
+	p := &Poller{
+		SensorGetter: &pollGetter{polls: []Sensors{
+			{3: temperatureSensor(`{"lastupdated":"2020-01-01T00:00:00","temperature":2000}`)},
+			{3: temperatureSensor(`{"lastupdated":"2020-01-01T00:00:00","temperature":2000}`)},
+			{3: temperatureSensor(`{"lastupdated":"2020-01-01T00:05:00","temperature":2150}`)},
+		}},
+		Interval: time.Millisecond,
+	}
+
+	err := p.Dial()
+	if err != nil {
+		t.Fatalf("unable to dial: %s", err)
+	}
+	defer p.Close()
+
+	// the unchanged poll is skipped, the next event is the update
+	e, err := p.ReadEvent()
+	if err != nil {
+		t.Fatalf("unable to read event: %s", err)
+	}
+
+	// the event should be identical to what the websocket would have sent
+	d := event.Decoder{TypeStore: &Sensors{3: Sensor{Type: "ZHATemperature"}}}
+	expected, err := d.Parse([]byte(`{"e":"changed","id":"3","r":"sensors","state":{"lastupdated":"2020-01-01T00:05:00","temperature":2150},"t":"event"}`))
+	if err != nil {
+		t.Fatalf("unable to parse expected event: %s", err)
+	}
+
+	if !reflect.DeepEqual(e.State, expected.State) || e.ID != expected.ID || e.Event != expected.Event || e.Resource != expected.Resource {
+		t.Errorf("polled event %+v differs from websocket event %+v", e, expected)
+	}
+}
+
+func TestPollerConfig(t *testing.T) {
+	battery := func(b float64) Sensor {
+		s := temperatureSensor(`{"lastupdated":"2020-01-01T00:00:00","temperature":2000}`)
+		s.Config = map[string]interface{}{"battery": b, "reachable": true}
+		return s
+	}
+
+	p := &Poller{
+		SensorGetter: &pollGetter{polls: []Sensors{{3: battery(100)}, {3: battery(90)}}},
+		Interval:     time.Millisecond,
+	}
+
+	err := p.Dial()
+	if err != nil {
+		t.Fatalf("unable to dial: %s", err)
+	}
+	defer p.Close()
+
+	e, err := p.ReadEvent()
+	if err != nil {
+		t.Fatalf("unable to read event: %s", err)
+	}
+
+	// only the battery changed, like the websocket would have sent it
+	if e.ID != 3 || e.Config == nil || e.Config.Battery == nil || *e.Config.Battery != 90 || e.Config.Reachable != nil {
+		t.Errorf("unexpected config event %+v", e)
+	}
+	if _, ok := e.State.(*event.EmptyState); !ok {
+		t.Errorf("config events should have no state: %T", e.State)
+	}
+}
+
+func TestPollerClose(t *testing.T) {
+	p := &Poller{
+		SensorGetter: &pollGetter{polls: []Sensors{{}}},
+		Interval:     time.Hour,
+	}
+
+	err := p.Dial()
+	if err != nil {
+		t.Fatalf("unable to dial: %s", err)
+	}
+
+	failed := make(chan error)
+	go func() {
+		_, err := p.ReadEvent()
+		failed <- err
+	}()
+
+	p.Close()
+	if err := <-failed; err == nil {
+		t.Errorf("expected ReadEvent to fail once closed")
+	}
+}
+
+// failingReader never dials
+type failingReader struct{}
+
+func (failingReader) ReadEvent() (*event.Event, error) {
+	return nil, errors.New("not connected")
+}
+func (failingReader) Dial() error {
+	return errors.New("connection refused")
+}
+func (failingReader) Close() error {
+	return nil
+}
+
+func TestFallbackReader(t *testing.T) {
+	poller := &Poller{SensorGetter: &pollGetter{polls: []Sensors{{}}}}
+	f := &fallbackReader{primary: failingReader{}, fallback: poller, after: 2}
+
+	if f.Dial() == nil {
+		t.Fatalf("the first failure should not fall back")
+	}
+
+	err := f.Dial()
+	if err != nil {
+		t.Fatalf("expected the second failure to fall back to polling: %s", err)
+	}
+
+	if f.current != poller {
+		t.Errorf("expected to read from the poller, got %T", f.current)
+	}
+
+	f.Close()
+}
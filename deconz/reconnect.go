@@ -0,0 +1,91 @@
+package deconz
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Defaults of ReconnectConfig and Config.Keepalive
+const (
+	DefaultReconnectMinDelay = 1 * time.Second
+	DefaultReconnectMaxDelay = 1 * time.Minute
+	DefaultReconnectJitter   = 0.2
+	DefaultKeepalive         = 30 * time.Second
+)
+
+// ReconnectConfig configures how the websocket is redialed after the
+// connection to deCONZ failed
+type ReconnectConfig struct {
+	// MinDelay is the delay before redialing, it is doubled after every
+	// failed attempt. It defaults to 1s
+	MinDelay time.Duration `yaml:",omitempty"`
+	// MaxDelay caps the delay, it defaults to 1m
+	MaxDelay time.Duration `yaml:",omitempty"`
+	// Jitter is the fraction of the delay randomly added or subtracted, to
+	// keep multiple instances from redialing in lockstep. It defaults to 0.2
+	// and a negative value disables it
+	Jitter float64 `yaml:",omitempty"`
+}
+
+// ConnectionState is the state of the websocket connection to deCONZ
+type ConnectionState int
+
+// Connection states
+const (
+	Disconnected ConnectionState = iota
+	Connected
+)
+
+func (s ConnectionState) String() string {
+	if s == Connected {
+		return "connected"
+	}
+	return "disconnected"
+}
+
+// backoff hands out delays between reconnect attempts
+type backoff struct {
+	config   ReconnectConfig
+	attempts int
+}
+
+// newBackoff returns a backoff with defaults filled in
+func newBackoff(c ReconnectConfig) *backoff {
+	if c.MinDelay == 0 {
+		c.MinDelay = DefaultReconnectMinDelay
+	}
+	if c.MaxDelay == 0 {
+		c.MaxDelay = DefaultReconnectMaxDelay
+	}
+	if c.MaxDelay < c.MinDelay {
+		c.MaxDelay = c.MinDelay
+	}
+	if c.Jitter == 0 {
+		c.Jitter = DefaultReconnectJitter
+	}
+	if c.Jitter < 0 {
+		c.Jitter = 0
+	}
+
+	return &backoff{config: c}
+}
+
+// next returns the delay before the next attempt
+func (b *backoff) next() time.Duration {
+	d := b.config.MinDelay
+	for i := 0; i < b.attempts && d < b.config.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > b.config.MaxDelay {
+		d = b.config.MaxDelay
+	}
+	b.attempts++
+
+	d += time.Duration((rand.Float64()*2 - 1) * b.config.Jitter * float64(d))
+	return d.Round(time.Millisecond)
+}
+
+// reset starts over from MinDelay
+func (b *backoff) reset() {
+	b.attempts = 0
+}
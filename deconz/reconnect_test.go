@@ -0,0 +1,35 @@
+package deconz
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(ReconnectConfig{MinDelay: time.Second, MaxDelay: 5 * time.Second, Jitter: 0.5})
+
+	// delays double from MinDelay and are capped at MaxDelay, give or take the jitter
+	for i, expected := range []time.Duration{1, 2, 4, 5, 5} {
+		expected *= time.Second
+		d := b.next()
+		if d < expected/2 || d > expected*3/2 {
+			t.Errorf("delay %d: expected %s give or take 50%%, got %s", i, expected, d)
+		}
+	}
+
+	b.reset()
+	if d := b.next(); d > 1500*time.Millisecond {
+		t.Errorf("expected reset to start over from MinDelay, got %s", d)
+	}
+}
+
+func TestBackoffWithoutJitter(t *testing.T) {
+	b := newBackoff(ReconnectConfig{MinDelay: time.Second, Jitter: -1})
+
+	for i, expected := range []time.Duration{1, 2, 4} {
+		expected *= time.Second
+		if d := b.next(); d != expected {
+			t.Errorf("delay %d: expected exactly %s, got %s", i, expected, d)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package deconz
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resourceCache caches sensors, lights or groups by id, unknown ids are
+// refetched at most every refetch interval
+type resourceCache struct {
+	mu      sync.Mutex
+	cache   map[int]interface{}
+	fetched time.Time
+	stale   bool
+}
+
+// lookup returns resource i, fetching resources if they were never fetched,
+// were invalidated or i is unknown and interval has passed since the last
+// fetch. name is the plural name of the resources, e.g. lights. If want is
+// set, a cached resource it does not want is treated as unknown
+func (c *resourceCache) lookup(i int, name string, interval time.Duration, fetch func() (map[int]interface{}, error), want func(interface{}) bool) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, cached := c.cache[i]
+	if cached && want != nil {
+		cached = want(v)
+	}
+	if c.cache == nil || c.stale || (!cached && refetch(c.fetched, interval)) {
+		err := c.populate(fetch)
+		if err != nil && c.cache == nil {
+			return nil, fmt.Errorf("unable to fetch %s: %s", name, err)
+		}
+		// fall back to what we know if resources cannot be refetched
+		if err != nil {
+			log.Printf("unable to refetch %s: %s", name, err)
+		}
+	}
+
+	if v, found := c.cache[i]; found {
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("no such %s", strings.TrimSuffix(name, "s"))
+}
+
+// refresh fetches resources, regardless of the refetch interval
+func (c *resourceCache) refresh(fetch func() (map[int]interface{}, error)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.populate(fetch)
+}
+
+// update replaces cached resource i with what change returns for it
+func (c *resourceCache) update(i int, name string, change func(interface{}) interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cache == nil {
+		return fmt.Errorf("no %s cached", name)
+	}
+
+	v, found := c.cache[i]
+	if !found {
+		return fmt.Errorf("no such %s", strings.TrimSuffix(name, "s"))
+	}
+
+	c.cache[i] = change(v)
+
+	return nil
+}
+
+// invalidate makes the next lookup refetch, regardless of the refetch interval
+func (c *resourceCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stale = true
+}
+
+// populate fetches resources, c.mu must be held
+func (c *resourceCache) populate(fetch func() (map[int]interface{}, error)) error {
+	// failed attempts counts towards the rate limit and clears invalidation as well,
+	// deCONZ being unreachable should not turn every lookup into a request
+	c.fetched = time.Now()
+	c.stale = false
+
+	resources, err := fetch()
+	if err != nil {
+		return err
+	}
+
+	c.cache = resources
+
+	return nil
+}
+
+// refetch reports whether enough time has passed since fetched to refetch
+// because of an unknown id
+func refetch(fetched time.Time, interval time.Duration) bool {
+	if interval == 0 {
+		interval = DefaultRefetchInterval
+	}
+
+	return time.Since(fetched) >= interval
+}
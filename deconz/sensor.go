@@ -1,11 +1,40 @@
 package deconz
 
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
 // Sensors is a map of sensors indexed by their id
 type Sensors map[int]Sensor
 
 // Sensor is a deCONZ sensor, not that we only implement fields needed
-// for event parsing to work
+// for event parsing to work and for describing the sensor to sinks
 type Sensor struct {
-	Type string
-	Name string
+	Type             string
+	Name             string
+	ManufacturerName string
+	ModelID          string
+	UniqueID         string
+	Config           map[string]interface{}
+	// State is the state of the sensor when it was fetched, it is decoded
+	// the same way as states from events
+	State json.RawMessage
+}
+
+// LookupType returns the type of sensor i, which lets Sensors decode events
+func (s Sensors) LookupType(i int) (string, error) {
+	sensor, found := s[i]
+	if !found {
+		return "", errors.New("no such sensor")
+	}
+
+	return sensor.Type, nil
+}
+
+// Virtual reports whether the sensor is a CLIP sensor, created through the
+// REST API rather than being a physical device
+func (s *Sensor) Virtual() bool {
+	return strings.HasPrefix(s.Type, "CLIP")
 }
@@ -2,7 +2,9 @@ package deconz
 
 import (
 	"fmt"
+	"log"
 	"strconv"
+	"time"
 
 	"github.com/fasmide/deflux/deconz/event"
 )
@@ -11,18 +13,133 @@ import (
 type SensorEvent struct {
 	*Sensor
 	*event.Event
+	// Received is when the event was read from deCONZ
+	Received time.Time
+	// Gateway is the name of the gateway the event was read from
+	Gateway string
+}
+
+// Measurement is a single time series sample
+type Measurement struct {
+	Name   string
+	Tags   map[string]string
+	Fields map[string]interface{}
+	Time   time.Time
 }
 
 type fielder interface {
 	Fields() map[string]interface{}
 }
 
-// Timeseries returns tags and fields for use in influxdb
+type timer interface {
+	Time() (time.Time, error)
+}
+
+// Timeseries returns tags and fields of the sensor state for use in influxdb
 func (s *SensorEvent) Timeseries() (map[string]string, map[string]interface{}, error) {
 	f, ok := s.Event.State.(fielder)
 	if !ok {
-		return nil, nil, fmt.Errorf("this event (%T:%s) has no time series data", s.State, s.Name)
+		return nil, nil, fmt.Errorf("this event (%T:%s) has no time series data", s.Event.State, s.Name)
+	}
+
+	// influxdb refuses points without fields, e.g. from unknown types with only strings
+	fields := f.Fields()
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("this event (%T:%s) has no time series data", s.Event.State, s.Name)
+	}
+
+	return s.tags(), fields, nil
+}
+
+// ConfigTimeseries returns tags and fields of the sensor config for use in influxdb
+func (s *SensorEvent) ConfigTimeseries() (map[string]string, map[string]interface{}, error) {
+	if s.Event.Config == nil {
+		return nil, nil, fmt.Errorf("this event (%s) has no config", s.Name)
+	}
+
+	fields := s.Event.Config.Fields()
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("this event (%s) has no config time series data", s.Name)
+	}
+
+	return s.tags(), fields, nil
+}
+
+// Measurements returns the sensor state as a deflux_<type> measurement and
+// the sensor config as a deflux_config measurement, whichever the event carries
+func (s *SensorEvent) Measurements() ([]Measurement, error) {
+	var measurements []Measurement
+
+	tags, fields, stateErr := s.Timeseries()
+	if stateErr == nil {
+		// fall back to the time we received the event if deCONZ did not tell us when it happened
+		t, err := s.Time()
+		if err != nil {
+			log.Printf("using receive time for event from %s: %s", s.Name, err)
+		}
+
+		measurements = append(measurements, Measurement{
+			Name:   fmt.Sprintf("deflux_%s", s.Sensor.Type),
+			Tags:   tags,
+			Fields: fields,
+			Time:   t,
+		})
+	}
+
+	// config carries no timestamp, it changed when we received it
+	tags, fields, err := s.ConfigTimeseries()
+	if err == nil {
+		measurements = append(measurements, Measurement{
+			Name:   "deflux_config",
+			Tags:   tags,
+			Fields: fields,
+			Time:   s.received(),
+		})
+	}
+
+	if len(measurements) == 0 {
+		return nil, stateErr
+	}
+
+	return measurements, nil
+}
+
+// Time returns when deCONZ last updated the state of this event. If the state
+// carries no usable lastupdated the time the event was received is returned
+// together with an error explaining why
+func (s *SensorEvent) Time() (time.Time, error) {
+	t, ok := s.Event.State.(timer)
+	if !ok {
+		return s.received(), fmt.Errorf("this event (%T:%s) has no lastupdated", s.Event.State, s.Name)
+	}
+
+	updated, err := t.Time()
+	if err != nil {
+		return s.received(), err
+	}
+
+	return updated, nil
+}
+
+func (s *SensorEvent) tags() map[string]string {
+	tags := map[string]string{"name": s.Name, "type": s.Sensor.Type, "id": strconv.Itoa(s.Event.ID)}
+
+	if s.Sensor.Virtual() {
+		tags["virtual"] = "true"
+	}
+
+	return gatewayTag(tags, s.Gateway)
+}
+
+// gatewayTag tags with the gateway, if it is named
+func gatewayTag(tags map[string]string, gateway string) map[string]string {
+	if gateway != "" {
+		tags["gateway"] = gateway
 	}
 
-	return map[string]string{"name": s.Name, "type": s.Sensor.Type, "id": strconv.Itoa(s.Event.ID)}, f.Fields(), nil
+	return tags
+}
+
+func (s *SensorEvent) received() time.Time {
+	return receivedOrNow(s.Received)
 }
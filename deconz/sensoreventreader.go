@@ -1,9 +1,13 @@
 package deconz
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
-	"time"
+	"fmt"
 	"log"
+	"sync/atomic"
+	"time"
 
 	"github.com/fasmide/deflux/deconz/event"
 )
@@ -13,6 +17,37 @@ type SensorLookup interface {
 	LookupSensor(int) (*Sensor, error)
 }
 
+// LightLookup represents an interface for light lookup
+type LightLookup interface {
+	LookupLight(int) (*Light, error)
+}
+
+// GroupLookup represents an interface for group lookup
+type GroupLookup interface {
+	LookupGroup(int) (*Group, error)
+}
+
+// invalidator is implemented by lookups that cache sensors
+type invalidator interface {
+	Invalidate()
+}
+
+// updater is implemented by lookups that can apply changed events to cached sensors
+type updater interface {
+	Update(*event.Event) error
+}
+
+// sceneLookup is implemented by group lookups that refetch groups, rate
+// limited, when a scene is unknown
+type sceneLookup interface {
+	LookupScene(group, scene int) (*Group, *Scene, error)
+}
+
+// configurer is implemented by states whose fields depend on the sensor config
+type configurer interface {
+	ApplyConfig(json.RawMessage) error
+}
+
 // EventReader interface
 type EventReader interface {
 	ReadEvent() (*event.Event, error)
@@ -20,22 +55,38 @@ type EventReader interface {
 	Close() error
 }
 
-// SensorEventReader reads events from an event.reader and returns SensorEvents
+// SensorEventReader reads events from an event.reader and returns SensorEvents,
+// LightEvents and GroupEvents. Light and group events are dropped if there is
+// no lookup for them
 type SensorEventReader struct {
-	lookup SensorLookup
-	reader EventReader
-	running bool
-}
+	lookup   SensorLookup
+	lights   LightLookup
+	groups   GroupLookup
+	reader   EventReader
+	started  int32
+	counters counters
 
+	// gateway names the gateway events are read from
+	gateway string
 
+	// reconnect configures delays between redialing deCONZ
+	reconnect ReconnectConfig
+	state     ConnectionState
 
+	// lastSeen is when the state of every sensor was last updated, as far as
+	// we know, it is only used from the reader goroutine
+	lastSeen map[int]time.Time
 
+	// StateChanged is called from the reader goroutine whenever the
+	// connection to deCONZ is established or lost, it must be set before Start
+	StateChanged func(ConnectionState)
+}
 
+// Start starts a goroutine reading events into the given channel until ctx
+// is cancelled, the channel is closed once the connection to deCONZ is closed.
+// It returns immediately
+func (r *SensorEventReader) Start(ctx context.Context, out chan ResourceEvent) error {
 
-// starts a thread reading events into the given channel
-// returns immediately
-func (r *SensorEventReader) Start(out chan *SensorEvent) error {
-	
 	if r.lookup == nil {
 		return errors.New("Cannot run without a SensorLookup from which to lookup sensors")
 	}
@@ -43,61 +94,389 @@ func (r *SensorEventReader) Start(out chan *SensorEvent) error {
 		return errors.New("Cannot run without a EventReader from which to read events")
 	}
 
-	if r.running {
+	if !atomic.CompareAndSwapInt32(&r.started, 0, 1) {
 		return errors.New("Reader is already running.")
 	}
 
-	r.running = true
-	
+	// closing the reader is the only way to interrupt a blocking ReadEvent
+	stopped := make(chan struct{})
 	go func() {
-		REDIAL:
-		for r.running {
-			// establish connection
-			for r.running {
-				err := r.reader.Dial()
-				if err != nil {
-					log.Printf("Error connecting Deconz websocket: %s\nAttempting reconnect in 5s...", err)
-					time.Sleep(5 * time.Second) // TODO configurable delay
-				} else {
-					log.Printf("Deconz websocket connected")
-					break
-				}
+		select {
+		case <-ctx.Done():
+			r.reader.Close()
+		case <-stopped:
+		}
+	}()
+
+	go func() {
+		defer close(out)
+		defer close(stopped)
+
+		backoff := newBackoff(r.reconnect)
+		for dialed := false; ctx.Err() == nil; dialed = true {
+			if !r.dial(ctx, backoff) {
+				break
 			}
-			// read events until connection fails
-			for r.running {
-				e, err := r.reader.ReadEvent()
-				if err != nil {
-					if eerr, ok := err.(event.EventError) ; ok && eerr.Recoverable() {
-						log.Printf("Dropping event due to error: %s", err)
-						continue
-					}
-					continue REDIAL
-				}
-				// we only care about sensor events
-				if e.Resource != "sensors" {
-					log.Printf("Dropping non-sensor event type %s", e.Resource)
-					continue;
-				}
-
-				sensor, err := r.lookup.LookupSensor(e.ID)
-				if err != nil {
-					log.Printf("Dropping event. Could not lookup sensor for id %d: %s", e.ID, err)
-					continue
-				}
-				// send event on channel
-				out <- &SensorEvent{Event: e, Sensor: sensor}
+
+			// changes while we were disconnected never reaches the websocket
+			r.resync(ctx, out, dialed)
+
+			connected := time.Now()
+			r.read(ctx, out)
+			r.setState(Disconnected)
+			if ctx.Err() != nil {
+				break
+			}
+
+			// connections failing right after being dialed should not be redialed in a tight loop
+			if time.Since(connected) > backoff.config.MaxDelay {
+				backoff.reset()
+			}
+
+			delay := backoff.next()
+			log.Printf("Reconnecting Deconz websocket in %s...", delay)
+			if !sleep(ctx, delay) {
+				break
 			}
 		}
-		// if not running, close connection and return from goroutine
+
+		// we are done, close the connection and return from goroutine
 		r.reader.Close()
 		log.Printf("Deconz websocket closed")
 	}()
+
+	return nil
+}
+
+// dial connects to deconz, retrying with backoff until it succeeds or ctx is cancelled
+func (r *SensorEventReader) dial(ctx context.Context, b *backoff) bool {
+	for {
+		err := r.reader.Dial()
+		if err == nil {
+			log.Printf("Deconz websocket connected")
+			r.setState(Connected)
+			// ctx could have been cancelled while we dialed, before there was a connection to close
+			return ctx.Err() == nil
+		}
+
+		delay := b.next()
+		log.Printf("Error connecting Deconz websocket: %s\nAttempting reconnect in %s...", err, delay)
+		if !sleep(ctx, delay) {
+			return false
+		}
+	}
+}
+
+// setState hands changes of the connection state to StateChanged
+func (r *SensorEventReader) setState(s ConnectionState) {
+	if s == r.state {
+		return
+	}
+
+	r.state = s
+	if r.StateChanged != nil {
+		r.StateChanged(s)
+	}
+}
+
+// sleep waits for d, it returns false if ctx was cancelled before
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// read reads events until the connection fails or ctx is cancelled
+func (r *SensorEventReader) read(ctx context.Context, out chan ResourceEvent) {
+	for {
+		e, err := r.reader.ReadEvent()
+		if err != nil {
+			if eerr, ok := err.(event.EventError); ok && eerr.Recoverable() {
+				log.Printf("Dropping event due to error: %s", err)
+				continue
+			}
+			if ctx.Err() == nil {
+				log.Printf("Deconz websocket failed: %s", err)
+			}
+			return
+		}
+
+		var re ResourceEvent
+		switch e.Resource {
+		case "sensors":
+			re = r.sensorEvent(e)
+		case "lights":
+			re = r.lightEvent(e)
+		case "groups":
+			re = r.groupEvent(e)
+		case "scenes":
+			re = r.sceneEvent(e)
+		default:
+			log.Printf("Dropping %s event", e.Resource)
+		}
+
+		if re == nil {
+			continue
+		}
+
+		// send event on channel
+		select {
+		case out <- re:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sensorEvent looks up the sensor of e, it returns nil if there is nothing to emit
+func (r *SensorEventReader) sensorEvent(e *event.Event) ResourceEvent {
+	// cached sensors are outdated once sensors are added or deleted
+	if e.Event == "added" || e.Event == "deleted" {
+		log.Printf("Sensor %d was %s", e.ID, e.Event)
+		if i, ok := r.lookup.(invalidator); ok {
+			i.Invalidate()
+		}
+		return nil
+	}
+
+	// renames and config changes arrive as changed events without state
+	if e.NewName != "" || len(e.RawConfig) > 0 {
+		if u, ok := r.lookup.(updater); ok {
+			err := u.Update(e)
+			if err != nil {
+				log.Printf("Unable to update sensor %d: %s", e.ID, err)
+			}
+		}
+	}
+
+	// nothing left to emit from events that only renamed the sensor
+	if len(e.RawState) == 0 && e.Config == nil {
+		return nil
+	}
+
+	sensor, err := r.lookup.LookupSensor(e.ID)
+	if err != nil {
+		log.Printf("Dropping event. Could not lookup sensor for id %d: %s", e.ID, err)
+		return nil
+	}
+
+	// the config of the sensor is up to date as changes in this event was applied above
+	if c, ok := e.State.(configurer); ok && len(sensor.Config) > 0 {
+		err = applyConfig(c, sensor.Config)
+		if err != nil {
+			log.Printf("Unable to apply config of sensor %d: %s", e.ID, err)
+		}
+	}
+
+	if c, ok := e.State.(counter); ok {
+		r.counters.track(e.ID, c)
+	}
+
+	r.seen(e)
+
+	return &SensorEvent{Event: e, Sensor: sensor, Received: time.Now(), Gateway: r.gateway}
+}
+
+// seen records when the state of e was last updated
+func (r *SensorEventReader) seen(e *event.Event) {
+	t, ok := e.State.(timer)
+	if !ok {
+		return
+	}
+
+	updated, err := t.Time()
+	if err != nil {
+		return
+	}
+
+	if r.lastSeen == nil {
+		r.lastSeen = make(map[int]time.Time)
+	}
+	if updated.After(r.lastSeen[e.ID]) {
+		r.lastSeen[e.ID] = updated
+	}
+}
+
+// resync fetches sensors and emits events for states updated since they were
+// last seen. The first time there is nothing to compare with, which is why
+// sensors are only recorded as seen unless emit is set
+func (r *SensorEventReader) resync(ctx context.Context, out chan ResourceEvent, emit bool) {
+	getter, ok := r.lookup.(SensorGetter)
+	if !ok {
+		return
+	}
+
+	sensors, err := getter.Sensors()
+	if err != nil {
+		log.Printf("Unable to resync sensors: %s", err)
+		return
+	}
+
+	// decode states the same way as states from the websocket
+	decoder := &event.Decoder{TypeStore: sensors, Registry: registryOf(r.reader)}
+
+	var synced int
+	for id, sensor := range *sensors {
+		e, updated, err := stateEvent(decoder, id, sensor)
+		if err != nil {
+			continue
+		}
+
+		last, known := r.lastSeen[id]
+		if !emit || (known && !updated.After(last)) {
+			r.seen(e)
+			continue
+		}
+
+		re := r.sensorEvent(e)
+		if re == nil {
+			continue
+		}
+
+		select {
+		case out <- re:
+			synced++
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if synced > 0 {
+		log.Printf("Resynced %d sensors updated while disconnected", synced)
+	}
+}
+
+// registryOf returns the registry states read by reader are decoded with,
+// nil means DefaultRegistry
+func registryOf(reader EventReader) event.Registry {
+	switch t := reader.(type) {
+	case *event.Reader:
+		return t.Registry
+	case *websocketReader:
+		return t.Registry
+	case *Poller:
+		return t.Registry
+	case *fallbackReader:
+		return registryOf(t.primary)
+	}
+
 	return nil
 }
 
+// lightEvent looks up the light of e, it returns nil if there is nothing to emit
+func (r *SensorEventReader) lightEvent(e *event.Event) ResourceEvent {
+	if r.lights == nil {
+		return nil
+	}
+
+	if outdatesCache(e) {
+		if i, ok := r.lights.(invalidator); ok {
+			i.Invalidate()
+		}
+	}
+
+	if len(e.RawState) == 0 {
+		return nil
+	}
+
+	light, err := r.lights.LookupLight(e.ID)
+	if err != nil {
+		log.Printf("Dropping event. Could not lookup light for id %d: %s", e.ID, err)
+		return nil
+	}
+
+	return &LightEvent{Event: e, Light: light, Received: time.Now(), Gateway: r.gateway}
+}
+
+// groupEvent looks up the group of e, it returns nil if there is nothing to emit
+func (r *SensorEventReader) groupEvent(e *event.Event) ResourceEvent {
+	if r.groups == nil {
+		return nil
+	}
 
+	if outdatesCache(e) {
+		if i, ok := r.groups.(invalidator); ok {
+			i.Invalidate()
+		}
+	}
+
+	if len(e.RawState) == 0 {
+		return nil
+	}
+
+	group, err := r.groups.LookupGroup(e.ID)
+	if err != nil {
+		log.Printf("Dropping event. Could not lookup group for id %d: %s", e.ID, err)
+		return nil
+	}
+
+	return &GroupEvent{Event: e, Group: group, Received: time.Now(), Gateway: r.gateway}
+}
+
+// sceneEvent looks up the group and scene of a scene-called event, it
+// returns nil if there is nothing to emit
+func (r *SensorEventReader) sceneEvent(e *event.Event) ResourceEvent {
+	if r.groups == nil {
+		return nil
+	}
+
+	// scenes are cached as part of their group
+	if outdatesCache(e) {
+		if i, ok := r.groups.(invalidator); ok {
+			i.Invalidate()
+		}
+	}
+
+	if e.Event != "scene-called" {
+		return nil
+	}
+
+	group, scene, err := lookupScene(r.groups, e.GroupID, e.SceneID)
+	if err != nil {
+		log.Printf("Dropping event. Could not lookup scene %d in group %d: %s", e.SceneID, e.GroupID, err)
+		return nil
+	}
+
+	return &SceneEvent{Event: e, Group: group, Scene: scene, Received: time.Now(), Gateway: r.gateway}
+}
+
+// lookupScene looks up scene s of group g, through l if it can refetch
+// groups when the scene is unknown
+func lookupScene(l GroupLookup, g, s int) (*Group, *Scene, error) {
+	if sl, ok := l.(sceneLookup); ok {
+		return sl.LookupScene(g, s)
+	}
+
+	group, err := l.LookupGroup(g)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scene, found := group.Scene(s)
+	if !found {
+		return nil, nil, errors.New("no such scene")
+	}
+
+	return group, scene, nil
+}
+
+// outdatesCache reports whether e outdates cached lights or groups
+func outdatesCache(e *event.Event) bool {
+	return e.Event == "added" || e.Event == "deleted" || e.NewName != ""
+}
+
+// applyConfig applies a sensor config to a state
+func applyConfig(c configurer, config map[string]interface{}) error {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("unable to marshal config: %s", err)
+	}
 
-// Close closes the reader, closing the connection to deconz and terminating the goroutine
-func (r *SensorEventReader) StopReadEvents() {
-	r.running = false
+	return c.ApplyConfig(raw)
 }
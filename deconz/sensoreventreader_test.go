@@ -1,8 +1,13 @@
 package deconz
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/fasmide/deflux/deconz/event"
 )
@@ -35,13 +40,14 @@ func (t testReader) Close() error {
 }
 func TestSensorEventReader(t *testing.T) {
 
-	r := SensorEventReader{reader: testReader{}}
-	channel := make(chan *SensorEvent)
-	err := r.Start(channel)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := SensorEventReader{lookup: &testLookup{}, reader: testReader{}}
+	channel := make(chan ResourceEvent)
+	err := r.Start(ctx, channel)
 	if err != nil {
 		t.Fail()
 	}
-	e := <-channel
+	e := (<-channel).(*SensorEvent)
 	if strconv.Itoa(e.Event.ID) != "5" {
 		t.Fail()
 	}
@@ -61,4 +67,266 @@ func TestSensorEventReader(t *testing.T) {
 		t.Fail()
 	}
 
+	ts, err := e.Time()
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	if !ts.Equal(time.Date(2018, 3, 13, 19, 46, 3, 0, time.UTC)) {
+		t.Fail()
+	}
+
+	// cancelling should stop the reader and close the channel
+	cancel()
+	for range channel {
+	}
+}
+
+const thermostatEventPayload = `{"e":"changed","id":"12","r":"sensors","state":{"lastupdated":"2020-01-03T08:02:11","on":true,"temperature":1950,"valve":30},"t":"event"}`
+
+// thermostatLookup knows a single thermostat and its config
+type thermostatLookup struct{}
+
+func (t *thermostatLookup) LookupSensor(i int) (*Sensor, error) {
+	return &Sensor{Name: "Living room", Type: "ZHAThermostat", Config: map[string]interface{}{
+		"heatsetpoint": float64(2100),
+		"mode":         "auto",
+		"offset":       float64(-50),
+		"battery":      float64(80),
+	}}, nil
+}
+
+func (t *thermostatLookup) LookupType(i int) (string, error) {
+	return "ZHAThermostat", nil
+}
+
+// payloadReader returns the same payload over and over
+type payloadReader string
+
+func (p payloadReader) ReadEvent() (*event.Event, error) {
+	d := event.Decoder{TypeStore: &thermostatLookup{}}
+	return d.Parse([]byte(p))
+}
+func (p payloadReader) Dial() error {
+	return nil
+}
+func (p payloadReader) Close() error {
+	return nil
+}
+
+func TestSensorEventReaderThermostat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := SensorEventReader{lookup: &thermostatLookup{}, reader: payloadReader(thermostatEventPayload)}
+	channel := make(chan ResourceEvent)
+	err := r.Start(ctx, channel)
+	if err != nil {
+		t.Fatalf("unable to start reader: %s", err)
+	}
+
+	e := (<-channel).(*SensorEvent)
+	_, fields, err := e.Timeseries()
+	if err != nil {
+		t.Fatalf("thermostat has no time series: %s", err)
+	}
+
+	if fields["temperature"] != 19.5 || fields["valve"] != 30 || fields["heatsetpoint"] != 21.0 || fields["mode"] != "auto" || fields["offset"] != -0.5 {
+		t.Errorf("unexpected thermostat fields: %v", fields)
+	}
+}
+
+const lightEventPayload = `{"e":"changed","id":"4","r":"lights","state":{"bri":180,"on":true,"reachable":true},"t":"event"}`
+
+// lightLookup knows a single light
+type lightLookup struct{}
+
+func (l lightLookup) LookupLight(i int) (*Light, error) {
+	return &Light{Type: "Color temperature light", Name: "Kitchen"}, nil
+}
+
+func TestSensorEventReaderLight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := SensorEventReader{lookup: &testLookup{}, lights: lightLookup{}, reader: payloadReader(lightEventPayload)}
+	channel := make(chan ResourceEvent)
+	err := r.Start(ctx, channel)
+	if err != nil {
+		t.Fatalf("unable to start reader: %s", err)
+	}
+
+	measurements, err := (<-channel).Measurements()
+	if err != nil {
+		t.Fatalf("light has no measurements: %s", err)
+	}
+
+	m := measurements[0]
+	if m.Name != "deflux_light" || m.Tags["name"] != "Kitchen" || m.Tags["id"] != "4" {
+		t.Errorf("unexpected light measurement: %+v", m)
+	}
+	if m.Fields["bri"] != 180 || m.Fields["on"] != true {
+		t.Errorf("unexpected light fields: %v", m.Fields)
+	}
+}
+
+const sceneCalledPayload = `{"e":"scene-called","gid":"3","r":"scenes","scid":"2","t":"event"}`
+
+// groupLookup knows a single group with a single scene
+type groupLookup struct{}
+
+func (g groupLookup) LookupGroup(i int) (*Group, error) {
+	return &Group{Name: "Living room", Scenes: []Scene{{ID: 2, Name: "Movie"}}}, nil
+}
+
+func TestSensorEventReaderScene(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := SensorEventReader{lookup: &testLookup{}, groups: groupLookup{}, reader: payloadReader(sceneCalledPayload)}
+	channel := make(chan ResourceEvent)
+	err := r.Start(ctx, channel)
+	if err != nil {
+		t.Fatalf("unable to start reader: %s", err)
+	}
+
+	measurements, err := (<-channel).Measurements()
+	if err != nil {
+		t.Fatalf("scene has no measurements: %s", err)
+	}
+
+	m := measurements[0]
+	if m.Name != "deflux_scene" || m.Tags["group"] != "Living room" || m.Tags["scene"] != "Movie" {
+		t.Errorf("unexpected scene measurement: %+v", m)
+	}
+}
+
+// flakyReader fails to dial once, then drops every connection right away
+type flakyReader struct {
+	dials int
+}
+
+func (f *flakyReader) ReadEvent() (*event.Event, error) {
+	return nil, errors.New("connection reset by peer")
+}
+func (f *flakyReader) Dial() error {
+	f.dials++
+	if f.dials == 1 {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+func (f *flakyReader) Close() error {
+	return nil
+}
+
+func TestSensorEventReaderReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	states := make(chan ConnectionState, 10)
+	r := SensorEventReader{
+		lookup:    &testLookup{},
+		reader:    &flakyReader{},
+		reconnect: ReconnectConfig{MinDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond},
+		StateChanged: func(s ConnectionState) {
+			// the reader keeps going, never block it once the test has seen enough
+			select {
+			case states <- s:
+			default:
+			}
+		},
+	}
+	channel := make(chan ResourceEvent)
+	err := r.Start(ctx, channel)
+	if err != nil {
+		t.Fatalf("unable to start reader: %s", err)
+	}
+
+	// the reader should keep redialing, reporting every change of state
+	for _, expected := range []ConnectionState{Connected, Disconnected, Connected, Disconnected} {
+		select {
+		case s := <-states:
+			if s != expected {
+				t.Errorf("expected %s, got %s", expected, s)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", expected)
+		}
+	}
+
+	cancel()
+	for range channel {
+	}
+}
+
+// resyncLookup knows a single temperature sensor, which is updated after it
+// was first fetched
+type resyncLookup struct {
+	mu      sync.Mutex
+	fetches int
+}
+
+func (l *resyncLookup) Sensors() (*Sensors, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.fetches++
+	state := `{"lastupdated":"2020-01-01T00:00:00","temperature":2000}`
+	if l.fetches > 1 {
+		state = `{"lastupdated":"2020-01-01T00:05:00","temperature":2150}`
+	}
+
+	return &Sensors{3: Sensor{Type: "ZHATemperature", Name: "Attic", State: json.RawMessage(state)}}, nil
+}
+
+func (l *resyncLookup) LookupSensor(i int) (*Sensor, error) {
+	sensors, _ := l.Sensors()
+	s := (*sensors)[i]
+	return &s, nil
+}
+
+func TestSensorEventReaderResync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// flakyReader drops every connection, the resync after redialing should
+	// emit the state the sensor got while we were disconnected
+	r := SensorEventReader{
+		lookup:    &resyncLookup{},
+		reader:    &flakyReader{},
+		reconnect: ReconnectConfig{MinDelay: time.Millisecond},
+	}
+	channel := make(chan ResourceEvent)
+	err := r.Start(ctx, channel)
+	if err != nil {
+		t.Fatalf("unable to start reader: %s", err)
+	}
+
+	e := (<-channel).(*SensorEvent)
+	_, fields, err := e.Timeseries()
+	if err != nil {
+		t.Fatalf("resynced event has no time series: %s", err)
+	}
+
+	if e.Event.ID != 3 || fields["temperature"] != 21.5 {
+		t.Errorf("unexpected resynced event %d: %v", e.Event.ID, fields)
+	}
+
+	ts, _ := e.Time()
+	if !ts.Equal(time.Date(2020, 1, 1, 0, 5, 0, 0, time.UTC)) {
+		t.Errorf("resynced event should keep lastupdated, got %s", ts)
+	}
+}
+
+func TestRegistryOf(t *testing.T) {
+	registry := event.DefaultRegistry.Copy()
+	registry.Register(func() interface{} { return &event.ZHATemperature{} }, "ZHAUnknown")
+
+	// resynced states must be decoded with the registry of the event reader
+	primary := &event.Reader{Registry: registry}
+	for _, reader := range []EventReader{primary, &websocketReader{Reader: primary}, &Poller{Registry: registry}, &fallbackReader{primary: primary}} {
+		if _, found := registryOf(reader)["ZHAUnknown"]; !found {
+			t.Errorf("registry of %T was not found", reader)
+		}
+	}
 }
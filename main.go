@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
-	"time"
+	"regexp"
+	"sync"
+	"syscall"
 
 	"github.com/fasmide/deflux/deconz"
+	"github.com/fasmide/deflux/sink"
 	client "github.com/influxdata/influxdb1-client/v2"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -17,111 +23,220 @@ import (
 // YmlFileName is the filename
 const YmlFileName = "deflux.yml"
 
-// Configuration holds data for Deconz and influxdb configuration
+// Configuration holds data for Deconz and sink configuration
 type Configuration struct {
-	Deconz           deconz.Config
+	Deconz deconz.Config
+	// Gateways is used instead of Deconz when reading from more than one
+	// gateway, every gateway needs a unique name
+	Gateways []deconz.Config
+	Sinks    []sink.Config
+
+	// Influxdb and InfluxdbDatabase is how influxdb was configured before
+	// sinks existed, if present they are used as an additional influxdb sink
 	Influxdb         client.HTTPConfig
 	InfluxdbDatabase string
 }
 
+// exit codes
+const (
+	exitOK = iota
+	// exitSetup means we were unable to start
+	exitSetup
+	// exitFlush means events might have been lost while shutting down
+	exitFlush
+)
+
 func main() {
+	os.Exit(run())
+}
+
+// run runs deflux until SIGINT or SIGTERM and returns the exit code
+func run() int {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	config, err := loadConfiguration()
 	if err != nil {
 		log.Printf("no configuration could be found: %s", err)
 		outputDefaultConfiguration()
-		return
+		return exitOK
 	}
 
-	sensorChan, err := sensorEventChan(config.Deconz)
+	gateways, err := config.gateways()
 	if err != nil {
-		panic(err)
+		log.Printf("invalid configuration: %s", err)
+		return exitSetup
 	}
 
-	log.Printf("Connected to deCONZ at %s", config.Deconz.Addr)
-
-	// initial influx batch
-	batch, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  config.InfluxdbDatabase,
-		Precision: "s",
-	})
+	apis := make([]*deconz.API, len(gateways))
+	sensors := make(map[string]deconz.SensorGetter, len(gateways))
+	for i, gc := range gateways {
+		apis[i] = &deconz.API{Config: gc}
+		sensors[gc.Name] = apis[i]
+	}
 
+	sinks, err := config.sinks(sensors)
 	if err != nil {
-		panic(err)
+		log.Printf("unable to create sinks: %s", err)
+		return exitSetup
+	}
+
+	// every gateway is read by its own goroutine
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var channels []chan deconz.ResourceEvent
+	for _, api := range apis {
+		name := api.Config.Name
+		channel, err := sensorEventChan(readCtx, api, func(s deconz.ConnectionState) {
+			sinks.ConnectionState(name, s)
+		})
+		if err != nil {
+			log.Printf("unable to read events from deCONZ at %s: %s", api.Config.Addr, err)
+			// wait for gateways already started to close their websocket
+			cancel()
+			for range merge(channels...) {
+			}
+			sinks.Close()
+			return exitSetup
+		}
+
+		log.Printf("Reading events from deCONZ at %s", api.Config.Addr)
+		channels = append(channels, channel)
 	}
 
-	//TODO: figure out how to create a timer that is stopped
-	timeout := time.NewTimer(1 * time.Second)
-	timeout.Stop()
+	// the merged channel is closed once ctx is cancelled and every websocket is closed
+	for e := range merge(channels...) {
+		err := sinks.Add(e)
+		if err != nil {
+			log.Printf("not adding event to sinks: %s", err)
+		}
+	}
+
+	// restore default signal handling, a second signal kills us if flushing hangs
+	stop()
 
-	influxdb, err := client.NewHTTPClient(config.Influxdb)
+	log.Printf("Shutting down, flushing sinks")
+	err = sinks.Close()
 	if err != nil {
-		panic(err)
+		log.Printf("unable to flush sinks: %s", err)
+		return exitFlush
 	}
 
-	for {
+	return exitOK
+}
 
-		select {
-		case sensorEvent := <-sensorChan:
-			tags, fields, err := sensorEvent.Timeseries()
-			if err != nil {
-				log.Printf("not adding event to influx batch: %s", err)
-				continue
-			}
+// validGatewayName matches names that are safe in mqtt topics and ids
+var validGatewayName = regexp.MustCompile("^[A-Za-z0-9_-]+$")
 
-			pt, err := client.NewPoint(
-				fmt.Sprintf("deflux_%s", sensorEvent.Sensor.Type),
-				tags,
-				fields,
-				time.Now(), // TODO: we should use the time associated with the event...
-			)
+// gateways returns the gateways to read from, Deconz is only used if no
+// Gateways are configured
+func (c *Configuration) gateways() ([]deconz.Config, error) {
+	gateways := c.Gateways
+	if len(gateways) == 0 {
+		gateways = []deconz.Config{c.Deconz}
+	}
 
-			if err != nil {
-				panic(err)
-			}
+	// names keep ids from different gateways apart
+	names := make(map[string]bool, len(gateways))
+	for _, g := range gateways {
+		if len(gateways) > 1 && g.Name == "" {
+			return nil, fmt.Errorf("gateway at %s has no name", g.Addr)
+		}
+		if g.Name != "" && !validGatewayName.MatchString(g.Name) {
+			return nil, fmt.Errorf("gateway name %s may only contain letters, digits, _ and -", g.Name)
+		}
+		if names[g.Name] {
+			return nil, fmt.Errorf("gateway name %s is used more than once", g.Name)
+		}
+		names[g.Name] = true
+	}
 
-			batch.AddPoint(pt)
-			timeout.Reset(1 * time.Second)
+	return gateways, nil
+}
 
-		case <-timeout.C:
-			// when timer fires: save batch points, initialize a new batch
-			err := influxdb.Write(batch)
-			if err != nil {
-				panic(err)
-			}
+// sinks creates every configured sink, sensors are by gateway name
+func (c *Configuration) sinks(sensors map[string]deconz.SensorGetter) (sink.Multi, error) {
+	configs := append([]sink.Config{}, c.Sinks...)
+	if c.Influxdb.Addr != "" {
+		configs = append(configs, sink.Config{
+			Type: "influxdb",
+			Influxdb: &sink.InfluxdbConfig{
+				Addr:               c.Influxdb.Addr,
+				Username:           c.Influxdb.Username,
+				Password:           c.Influxdb.Password,
+				UserAgent:          c.Influxdb.UserAgent,
+				Database:           c.InfluxdbDatabase,
+				Timeout:            c.Influxdb.Timeout,
+				InsecureSkipVerify: c.Influxdb.InsecureSkipVerify,
+			},
+		})
+	}
 
-			log.Printf("Saved %d records to influxdb", len(batch.Points()))
-			// influx batch point
-			batch, err = client.NewBatchPoints(client.BatchPointsConfig{
-				Database:  config.InfluxdbDatabase,
-				Precision: "s",
-			})
+	if len(configs) == 0 {
+		return nil, errors.New("no sinks configured")
+	}
+
+	var sinks sink.Multi
+	for _, sc := range configs {
+		s, err := sink.New(sc, sensors)
+		if err != nil {
+			sinks.Close()
+			return nil, err
 		}
+		sinks = append(sinks, s)
 	}
+
+	return sinks, nil
 }
 
-func sensorEventChan(c deconz.Config) (chan *deconz.SensorEvent, error) {
+func sensorEventChan(ctx context.Context, d *deconz.API, stateChanged func(deconz.ConnectionState)) (chan deconz.ResourceEvent, error) {
 	// get an event reader from the API
-	d := deconz.API{Config: c}
 	reader, err := d.EventReader()
 	if err != nil {
 		return nil, err
 	}
 
-	// Dial the reader
-	err = reader.Dial()
-	if err != nil {
-		return nil, err
-	}
+	// keep the sensor cache fresh for as long as we are reading events
+	go d.RefreshSensors(ctx)
 
 	// create a new reader, embedding the event reader
 	sensorEventReader := d.SensorEventReader(reader)
-	channel := make(chan *deconz.SensorEvent)
-	// start it, it starts its own thread
-	sensorEventReader.Start(channel)
+	sensorEventReader.StateChanged = stateChanged
+	channel := make(chan deconz.ResourceEvent)
+	// start it, it starts its own thread and dials deconz
+	err = sensorEventReader.Start(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
 	// return the channel
 	return channel, nil
 }
 
+// merge returns a channel receiving events from every channel, it is closed
+// once they are all closed
+func merge(channels ...chan deconz.ResourceEvent) chan deconz.ResourceEvent {
+	out := make(chan deconz.ResourceEvent)
+
+	var wg sync.WaitGroup
+	wg.Add(len(channels))
+	for _, c := range channels {
+		go func(c chan deconz.ResourceEvent) {
+			defer wg.Done()
+			for e := range c {
+				out <- e
+			}
+		}(c)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
 func loadConfiguration() (*Configuration, error) {
 	data, err := readConfiguration()
 	if err != nil {
@@ -163,45 +278,24 @@ func readConfiguration() ([]byte, error) {
 	return data, nil
 }
 
-// influxdbConfigProxy proxies client.HTTPConfig into a yml capable
-// struct, its only used for encoding to yml as the yml package
-// have no problem skipping the Proxy field when decoding
-type influxdbConfigProxy struct {
-	Addr      string
-	Username  string
-	Password  string
-	UserAgent string
-}
-
 func outputDefaultConfiguration() {
 
 	c := defaultConfiguration()
 
 	// try to pair with deconz
-	u, err := url.Parse(c.Deconz.Addr)
-	if err == nil {
-		apikey, err := deconz.Pair(*u)
-		if err != nil {
-			log.Printf("unable to pair with deconz: %s, please fill out APIKey manually", err)
-		}
-		c.Deconz.APIKey = string(apikey)
+	apikey, err := c.Deconz.Pair()
+	if err != nil {
+		log.Printf("unable to pair with deconz: %s, please fill out APIKey manually", err)
 	}
+	c.Deconz.APIKey = string(apikey)
 
-	// we need to use a proxy struct to encode yml as the influxdb client configuration struct
-	// includes a Proxy: func() field that the yml encoder cannot handle
+	// the legacy influxdb fields are left out, new configurations should use sinks
 	yml, err := yaml.Marshal(struct {
-		Deconz           deconz.Config
-		Influxdb         influxdbConfigProxy
-		InfluxdbDatabase string
+		Deconz deconz.Config
+		Sinks  []sink.Config
 	}{
 		Deconz: c.Deconz,
-		Influxdb: influxdbConfigProxy{
-			Addr:      c.Influxdb.Addr,
-			Username:  c.Influxdb.Username,
-			Password:  c.Influxdb.Password,
-			UserAgent: c.Influxdb.UserAgent,
-		},
-		InfluxdbDatabase: c.InfluxdbDatabase,
+		Sinks:  c.Sinks,
 	})
 	if err != nil {
 		log.Fatalf("unable to generate default configuration: %s", err)
@@ -219,13 +313,18 @@ func defaultConfiguration() *Configuration {
 			Addr:   "http://127.0.0.1:8080/",
 			APIKey: "change me",
 		},
-		Influxdb: client.HTTPConfig{
-			Addr:      "http://127.0.0.1:8086/",
-			Username:  "change me",
-			Password:  "change me",
-			UserAgent: "Deflux",
+		Sinks: []sink.Config{
+			{
+				Type: "influxdb",
+				Influxdb: &sink.InfluxdbConfig{
+					Addr:      "http://127.0.0.1:8086/",
+					Username:  "change me",
+					Password:  "change me",
+					UserAgent: "Deflux",
+					Database:  "deconz",
+				},
+			},
 		},
-		InfluxdbDatabase: "deconz",
 	}
 
 	// lets see if we are able to discover a gateway, and overwrite parts of the
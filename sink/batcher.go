@@ -0,0 +1,154 @@
+package sink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+// batchDelay is how long the batcher waits for more points before writing
+const batchDelay = 1 * time.Second
+
+// statusError is returned when influxdb answers a write with an unexpected status
+type statusError struct {
+	sink string
+	code int
+	msg  []byte
+}
+
+func newStatusError(sink string, resp *http.Response) *statusError {
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return &statusError{sink: sink, code: resp.StatusCode, msg: msg}
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected statuscode from %s: %d\n%s", e.sink, e.code, e.msg)
+}
+
+// rejected reports whether influxdb refused the points themselves, e.g.
+// because of a field type conflict. Writing them again will never succeed
+func rejected(err error) bool {
+	se, ok := err.(*statusError)
+	return ok && se.code >= 400 && se.code < 500 && se.code != http.StatusTooManyRequests
+}
+
+// batcher collects points into batches, a batch is handed to write when
+// no points have been added for batchDelay. Batches that cannot be written
+// are spooled to disk if a spool is configured and dropped otherwise
+type batcher struct {
+	write  func([]*client.Point) error
+	spool  *spool
+	points chan *client.Point
+	done   chan struct{}
+
+	// err is why the last batch could not be written when closing
+	err error
+}
+
+// newBatcher creates a batcher and starts collecting points
+func newBatcher(write func([]*client.Point) error, c SpoolConfig) (*batcher, error) {
+	b := &batcher{
+		write:  write,
+		points: make(chan *client.Point),
+		done:   make(chan struct{}),
+	}
+
+	if c.Dir != "" {
+		var err error
+		b.spool, err = newSpool(c, write)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	go b.run()
+
+	return b, nil
+}
+
+// add adds a point to the current batch
+func (b *batcher) add(pt *client.Point) {
+	b.points <- pt
+}
+
+// close writes the current batch and stops the batcher, it returns an
+// error if the batch could not be written
+func (b *batcher) close() error {
+	close(b.points)
+	<-b.done
+
+	if b.spool != nil {
+		b.spool.close()
+	}
+
+	return b.err
+}
+
+func (b *batcher) run() {
+	defer close(b.done)
+
+	var batch []*client.Point
+
+	//TODO: figure out how to create a timer that is stopped
+	timeout := time.NewTimer(batchDelay)
+	timeout.Stop()
+
+	for {
+		select {
+		case pt, ok := <-b.points:
+			if !ok {
+				b.err = b.flush(batch)
+				return
+			}
+
+			batch = append(batch, pt)
+			timeout.Reset(batchDelay)
+
+		case <-timeout.C:
+			// when timer fires: save batch points, initialize a new batch
+			b.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+// flush writes a batch, it returns an error if the batch was not written
+// even if it was spooled
+func (b *batcher) flush(batch []*client.Point) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// keep batches in order, nothing can be written before the spool is empty
+	if b.spool != nil && !b.spool.empty() {
+		err := b.spool.add(batch)
+		if err != nil {
+			log.Printf("Dropping %d points: %s", len(batch), err)
+			return fmt.Errorf("unable to spool %d points: %s", len(batch), err)
+		}
+		return fmt.Errorf("spooled %d points behind earlier batches", len(batch))
+	}
+
+	err := b.write(batch)
+	if err == nil {
+		return nil
+	}
+
+	if b.spool == nil {
+		log.Printf("Dropping %d points: %s", len(batch), err)
+		return fmt.Errorf("unable to write %d points: %s", len(batch), err)
+	}
+
+	log.Printf("Spooling %d points: %s", len(batch), err)
+	serr := b.spool.add(batch)
+	if serr != nil {
+		log.Printf("Dropping %d points: %s", len(batch), serr)
+		return fmt.Errorf("unable to write %d points: %s", len(batch), err)
+	}
+
+	return fmt.Errorf("spooled %d points: %s", len(batch), err)
+}
@@ -0,0 +1,152 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/fasmide/deflux/deconz"
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+// InfluxdbConfig holds the configuration of an InfluxDB 1.x sink
+type InfluxdbConfig struct {
+	Addr      string
+	Username  string
+	Password  string
+	UserAgent string
+	Database  string
+	// Precision is one of ns, us, ms or s, it defaults to s. Door locks,
+	// alarms and keypads needs ms or finer to keep events within a second ordered
+	Precision string
+	// Timeout is how long a write may take, it defaults to 10s
+	Timeout time.Duration
+	// InsecureSkipVerify disables verifying the certificate of influxdb
+	InsecureSkipVerify bool
+	Spool              SpoolConfig
+}
+
+// Influxdb batches events and writes them as line protocol to the
+// InfluxDB 1.x /write endpoint
+type Influxdb struct {
+	config  InfluxdbConfig
+	url     string
+	client  *http.Client
+	batcher *batcher
+}
+
+// NewInfluxdb creates an Influxdb sink and starts its writer
+func NewInfluxdb(c InfluxdbConfig) (*Influxdb, error) {
+	if c.Precision == "" {
+		c.Precision = "s"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+
+	// the v1 api takes the same precisions as the v2 api
+	if _, ok := influxdb2Precisions[c.Precision]; !ok {
+		return nil, fmt.Errorf("unable to create influxdb sink: %s is not a known precision", c.Precision)
+	}
+
+	u, err := url.Parse(c.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create influxdb sink: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unable to create influxdb sink: unsupported protocol scheme: %s", u.Scheme)
+	}
+
+	u.Path = path.Join(u.Path, "write")
+	u.RawQuery = url.Values{
+		"db":        []string{c.Database},
+		"precision": []string{influxdb2Precisions[c.Precision]},
+	}.Encode()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+
+	i := &Influxdb{
+		config: c,
+		url:    u.String(),
+		client: &http.Client{Transport: transport, Timeout: c.Timeout},
+	}
+	i.batcher, err = newBatcher(i.write, c.Spool)
+	if err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Add converts the event into points and adds them to the current batch
+func (i *Influxdb) Add(e deconz.ResourceEvent) error {
+	pts, err := points(e)
+	if err != nil {
+		return err
+	}
+
+	for _, pt := range pts {
+		i.batcher.add(pt)
+	}
+	return nil
+}
+
+// Close writes the current batch, it returns an error if it could not be written
+func (i *Influxdb) Close() error {
+	return i.batcher.close()
+}
+
+func (i *Influxdb) write(points []*client.Point) error {
+	var body bytes.Buffer
+	for _, pt := range points {
+		body.WriteString(pt.PrecisionString(influxdb2Precisions[i.config.Precision]))
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, i.url, &body)
+	if err != nil {
+		return fmt.Errorf("unable to create request: %s", err)
+	}
+	req.Header.Set("User-Agent", i.config.UserAgent)
+	if i.config.Username != "" {
+		req.SetBasicAuth(i.config.Username, i.config.Password)
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to write to influxdb: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return newStatusError("influxdb", resp)
+	}
+
+	log.Printf("Saved %d records to influxdb", len(points))
+	return nil
+}
+
+// points converts an event into influxdb points
+func points(e deconz.ResourceEvent) ([]*client.Point, error) {
+	measurements, err := e.Measurements()
+	if err != nil {
+		return nil, err
+	}
+
+	pts := make([]*client.Point, 0, len(measurements))
+	for _, m := range measurements {
+		pt, err := client.NewPoint(m.Name, m.Tags, m.Fields, m.Time)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create point: %s", err)
+		}
+		pts = append(pts, pt)
+	}
+
+	return pts, nil
+}
@@ -0,0 +1,124 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/fasmide/deflux/deconz"
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Influxdb2Config holds the configuration of an InfluxDB 2.x sink
+type Influxdb2Config struct {
+	Addr   string
+	Org    string
+	Bucket string
+	Token  string
+	// Precision is one of ns, us, ms or s, it defaults to s
+	Precision string
+	Spool     SpoolConfig
+}
+
+// influxdb2Precisions maps the precisions of the v2 write api to
+// the precisions used when formatting line protocol
+var influxdb2Precisions = map[string]string{
+	"ns": "n",
+	"us": "u",
+	"ms": "ms",
+	"s":  "s",
+}
+
+// Influxdb2 batches events and writes them as line protocol to the
+// InfluxDB 2.x /api/v2/write endpoint
+type Influxdb2 struct {
+	config  Influxdb2Config
+	url     string
+	client  *http.Client
+	batcher *batcher
+}
+
+// NewInfluxdb2 creates an Influxdb2 sink and starts its writer
+func NewInfluxdb2(c Influxdb2Config) (*Influxdb2, error) {
+	if c.Precision == "" {
+		c.Precision = "s"
+	}
+
+	if _, ok := influxdb2Precisions[c.Precision]; !ok {
+		return nil, fmt.Errorf("unable to create influxdb2 sink: %s is not a known precision", c.Precision)
+	}
+
+	u, err := url.Parse(c.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create influxdb2 sink: %s", err)
+	}
+
+	u.Path = path.Join(u.Path, "api/v2/write")
+	u.RawQuery = url.Values{
+		"org":       []string{c.Org},
+		"bucket":    []string{c.Bucket},
+		"precision": []string{c.Precision},
+	}.Encode()
+
+	i := &Influxdb2{
+		config: c,
+		url:    u.String(),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	i.batcher, err = newBatcher(i.write, c.Spool)
+	if err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Add converts the event into points and adds them to the current batch
+func (i *Influxdb2) Add(e deconz.ResourceEvent) error {
+	pts, err := points(e)
+	if err != nil {
+		return err
+	}
+
+	for _, pt := range pts {
+		i.batcher.add(pt)
+	}
+	return nil
+}
+
+// Close writes the current batch, it returns an error if it could not be written
+func (i *Influxdb2) Close() error {
+	return i.batcher.close()
+}
+
+func (i *Influxdb2) write(points []*client.Point) error {
+	var body bytes.Buffer
+	for _, pt := range points {
+		body.WriteString(pt.PrecisionString(influxdb2Precisions[i.config.Precision]))
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, i.url, &body)
+	if err != nil {
+		return fmt.Errorf("unable to create request: %s", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Token %s", i.config.Token))
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to write to influxdb2: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return newStatusError("influxdb2", resp)
+	}
+
+	log.Printf("Saved %d records to influxdb2", len(points))
+	return nil
+}
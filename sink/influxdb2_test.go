@@ -0,0 +1,88 @@
+package sink
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInfluxdb2(t *testing.T) {
+	var body, query, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		query = r.URL.RawQuery
+		auth = r.Header.Get("Authorization")
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s, err := NewInfluxdb2(Influxdb2Config{
+		Addr:   server.URL,
+		Org:    "home",
+		Bucket: "deconz",
+		Token:  "secret",
+	})
+	if err != nil {
+		t.Logf("unable to create sink: %s", err)
+		t.FailNow()
+	}
+
+	err = s.Add(testEvent())
+	if err != nil {
+		t.Logf("unable to add event: %s", err)
+		t.FailNow()
+	}
+
+	// closing flushes the batch
+	s.Close()
+
+	expected := "deflux_ZHATemperature,id=1,name=Test\\ Sensor,type=ZHATemperature temperature=20.62 1520537724\n"
+	if body != expected {
+		t.Errorf("unexpected line protocol: %q", body)
+	}
+
+	if query != "bucket=deconz&org=home&precision=s" {
+		t.Errorf("unexpected query: %s", query)
+	}
+
+	if auth != "Token secret" {
+		t.Errorf("unexpected authorization: %s", auth)
+	}
+}
+
+func TestInfluxdb2Precision(t *testing.T) {
+	_, err := NewInfluxdb2(Influxdb2Config{Addr: "http://127.0.0.1:8086", Precision: "fortnight"})
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestInfluxdb2CloseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s, err := NewInfluxdb2(Influxdb2Config{Addr: server.URL, Org: "home", Bucket: "deconz"})
+	if err != nil {
+		t.Fatalf("unable to create sink: %s", err)
+	}
+
+	err = s.Add(testEvent())
+	if err != nil {
+		t.Fatalf("unable to add event: %s", err)
+	}
+
+	// the last batch is lost, which must not go unnoticed
+	err = s.Close()
+	if err == nil {
+		t.Errorf("closing should fail when the last batch cannot be written")
+	}
+}
@@ -0,0 +1,81 @@
+package sink
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fasmide/deflux/deconz"
+	"github.com/fasmide/deflux/deconz/event"
+)
+
+func TestInfluxdbPrecision(t *testing.T) {
+	var body, precision string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		precision = r.URL.Query().Get("precision")
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s, err := NewInfluxdb(InfluxdbConfig{Addr: server.URL, Database: "deconz", Precision: "ms"})
+	if err != nil {
+		t.Fatalf("unable to create sink: %s", err)
+	}
+
+	err = s.Add(&deconz.SensorEvent{
+		Sensor: &deconz.Sensor{Name: "Front door", Type: "ZHADoorLock"},
+		Event: &event.Event{
+			ID:    20,
+			State: &event.ZHADoorLock{State: event.State{Lastupdated: "2022-02-11T17:02:31.512"}, Lockstate: "locked"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to add event: %s", err)
+	}
+
+	// closing flushes the batch
+	s.Close()
+
+	expected := "deflux_ZHADoorLock,id=20,name=Front\\ door,type=ZHADoorLock locked=true,lockstate=\"locked\" 1644598951512\n"
+	if body != expected {
+		t.Errorf("unexpected line protocol: %q", body)
+	}
+
+	if precision != "ms" {
+		t.Errorf("unexpected precision: %s", precision)
+	}
+
+	_, err = NewInfluxdb(InfluxdbConfig{Addr: server.URL, Precision: "fortnight"})
+	if err == nil {
+		t.Errorf("unknown precisions should not be accepted")
+	}
+}
+
+func TestInfluxdbInsecureSkipVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// the certificate of the test server is self-signed
+	for _, insecure := range []bool{false, true} {
+		s, err := NewInfluxdb(InfluxdbConfig{Addr: server.URL, Database: "deconz", InsecureSkipVerify: insecure, Timeout: time.Second})
+		if err != nil {
+			t.Fatalf("unable to create sink: %s", err)
+		}
+
+		s.Add(testEvent())
+		err = s.Close()
+		if insecure && err != nil {
+			t.Errorf("unable to write skipping verification: %s", err)
+		}
+		if !insecure && err == nil {
+			t.Errorf("expected the self-signed certificate to be refused")
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/fasmide/deflux/deconz"
+)
+
+// mqttTimeout is how long we wait for the broker to acknowledge anything
+const mqttTimeout = 10 * time.Second
+
+// MQTTConfig holds the configuration of a MQTT sink
+type MQTTConfig struct {
+	// Broker is the address of the broker, e.g. tcp://127.0.0.1:1883
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// Topic is the prefix events are published below as <topic>/<type>/<id>,
+	// or <topic>/<gateway>/<type>/<id> for named gateways. It defaults to deflux
+	Topic string
+	QoS   byte
+	// Discovery is the Home Assistant discovery prefix, usually homeassistant,
+	// discovery messages are not published when it is empty
+	Discovery string
+}
+
+// MQTT publishes events as json and optionally announces sensors using
+// Home Assistant MQTT discovery
+type MQTT struct {
+	config MQTTConfig
+	client mqtt.Client
+
+	// announced holds what sensors was last announced with, by gateway and id
+	mu        sync.Mutex
+	announced map[string]announcement
+}
+
+// announcement is the name a sensor was announced with and the fields
+// announced so far, many states only send some of their fields in each event
+type announcement struct {
+	name   string
+	fields map[string]bool
+}
+
+// haDeviceClasses maps field names to Home Assistant device classes
+var haDeviceClasses = map[string]string{
+	"temperature": "temperature",
+	"humidity":    "humidity",
+	"pressure":    "pressure",
+	"lux":         "illuminance",
+	"fire":        "smoke",
+	"water":       "moisture",
+	"presence":    "motion",
+	"open":        "opening",
+	"vibration":   "vibration",
+	"CO":          "carbon_monoxide",
+	"lowbattery":  "battery",
+	"tampered":    "tamper",
+	"power":       "power",
+	"voltage":     "voltage",
+	"current":     "current",
+
+	"consumption_kwh": "energy",
+	"heatsetpoint":    "temperature",
+	"co2":             "carbon_dioxide",
+	"pm2_5":           "pm25",
+	"airqualityppb":   "volatile_organic_compounds_parts",
+	"moisture":        "moisture",
+}
+
+// haUnits maps field names to the units they are reported in
+var haUnits = map[string]string{
+	"temperature": "°C",
+	"humidity":    "%",
+	"pressure":    "hPa",
+	"lux":         "lx",
+	"power":       "W",
+	"voltage":     "V",
+	"current":     "A",
+
+	"consumption_kwh": "kWh",
+	"heatsetpoint":    "°C",
+	"co2":             "ppm",
+	"pm2_5":           "µg/m³",
+	"airqualityppb":   "ppb",
+	"moisture":        "%",
+}
+
+// haStateClasses maps fields that are not plain measurements to their state class
+var haStateClasses = map[string]string{
+	"consumption_kwh": "total_increasing",
+}
+
+// haInvalidChars matches characters not allowed in discovery object ids
+var haInvalidChars = regexp.MustCompile("[^a-zA-Z0-9_-]")
+
+// NewMQTT creates a MQTT sink and connects it to the broker
+func NewMQTT(c MQTTConfig) (*MQTT, error) {
+	if c.Topic == "" {
+		c.Topic = "deflux"
+	}
+	if c.ClientID == "" {
+		c.ClientID = "deflux"
+	}
+
+	m := &MQTT{config: c, announced: make(map[string]announcement)}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(c.Broker).
+		SetClientID(c.ClientID).
+		SetUsername(c.Username).
+		SetPassword(c.Password).
+		SetAutoReconnect(true).
+		SetWill(m.availabilityTopic(), "offline", c.QoS, true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			// sensors are announced again as the broker might have lost retained messages
+			m.mu.Lock()
+			m.announced = make(map[string]announcement)
+			m.mu.Unlock()
+			client.Publish(m.availabilityTopic(), c.QoS, true, "online")
+		})
+
+	m.client = mqtt.NewClient(opts)
+	err := wait(m.client.Connect())
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to mqtt broker %s: %s", c.Broker, err)
+	}
+
+	return m, nil
+}
+
+// Add publishes the event state to <topic>/<type>/<id> and its config to
+// <topic>/<type>/<id>/config, announcing fields of the sensor first if they
+// have not been announced with its current name. Light and group state is published to
+// <topic>/light/<id> and <topic>/group/<id> and never announced, scenes called
+// in a group are published to <topic>/group/<id>/scene
+func (m *MQTT) Add(re deconz.ResourceEvent) error {
+	switch e := re.(type) {
+	case *deconz.SensorEvent:
+		return m.addSensor(e)
+	case *deconz.LightEvent:
+		_, state, err := e.Timeseries()
+		if err != nil {
+			return err
+		}
+		return m.publish(fmt.Sprintf("%s/light/%d", m.topic(e.Gateway), e.Event.ID), state, e.Received)
+	case *deconz.GroupEvent:
+		_, state, err := e.Timeseries()
+		if err != nil {
+			return err
+		}
+		return m.publish(fmt.Sprintf("%s/group/%d", m.topic(e.Gateway), e.Event.ID), state, e.Received)
+	case *deconz.SceneEvent:
+		scene := map[string]interface{}{"scene": e.Scene.Name, "scene_id": e.SceneID}
+		return m.publish(fmt.Sprintf("%s/group/%d/scene", m.topic(e.Gateway), e.GroupID), scene, e.Received)
+	default:
+		return fmt.Errorf("unable to publish %T", re)
+	}
+}
+
+// addSensor publishes a sensor event
+func (m *MQTT) addSensor(e *deconz.SensorEvent) error {
+	_, state, stateErr := e.Timeseries()
+	_, config, configErr := e.ConfigTimeseries()
+	if stateErr != nil && configErr != nil {
+		return stateErr
+	}
+
+	if stateErr == nil {
+		if m.config.Discovery != "" {
+			err := m.announce(e, state)
+			if err != nil {
+				return err
+			}
+		}
+
+		t, _ := e.Time()
+		err := m.publish(m.stateTopic(e), state, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	if configErr == nil {
+		err := m.publish(m.stateTopic(e)+"/config", config, e.Received)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publish publishes fields as json along with when they were last updated
+func (m *MQTT) publish(topic string, fields map[string]interface{}, t time.Time) error {
+	payload := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		payload[k] = v
+	}
+
+	if !t.IsZero() {
+		payload["lastupdated"] = t.UTC().Format(time.RFC3339Nano)
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to marshal event: %s", err)
+	}
+
+	err = wait(m.client.Publish(topic, m.config.QoS, false, b))
+	if err != nil {
+		return fmt.Errorf("unable to publish event: %s", err)
+	}
+
+	return nil
+}
+
+// Close marks deflux offline and disconnects from the broker
+func (m *MQTT) Close() error {
+	err := wait(m.client.Publish(m.availabilityTopic(), m.config.QoS, true, "offline"))
+	m.client.Disconnect(250)
+
+	return err
+}
+
+// announce publishes a retained Home Assistant discovery config for every
+// field of the sensor not yet announced with its current name
+func (m *MQTT) announce(e *deconz.SensorEvent, fields map[string]interface{}) error {
+	key := fmt.Sprintf("%s/%d", e.Gateway, e.Event.ID)
+
+	m.mu.Lock()
+	a, found := m.announced[key]
+	m.mu.Unlock()
+
+	// a renamed sensor is announced again with every field
+	if !found || a.name != e.Sensor.Name {
+		a = announcement{name: e.Sensor.Name, fields: make(map[string]bool)}
+	}
+
+	// publish in a predictable order
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		if !a.fields[field] {
+			names = append(names, field)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+
+	announced := make(map[string]bool, len(a.fields)+len(names))
+	for field := range a.fields {
+		announced[field] = true
+	}
+
+	for _, field := range names {
+		component, config := m.discoveryConfig(e, field, fields[field])
+		if component == "" {
+			announced[field] = true
+			continue
+		}
+
+		b, err := json.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("unable to marshal discovery config: %s", err)
+		}
+
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", m.config.Discovery, component, m.objectID(e), field)
+		err = wait(m.client.Publish(topic, m.config.QoS, true, b))
+		if err != nil {
+			return fmt.Errorf("unable to publish discovery config: %s", err)
+		}
+		announced[field] = true
+	}
+
+	m.mu.Lock()
+	m.announced[key] = announcement{name: e.Sensor.Name, fields: announced}
+	m.mu.Unlock()
+
+	return nil
+}
+
+// discoveryConfig returns the Home Assistant component and discovery config
+// of a single field, strings and other non measurable values are skipped
+func (m *MQTT) discoveryConfig(e *deconz.SensorEvent, field string, value interface{}) (string, map[string]interface{}) {
+	config := map[string]interface{}{
+		"name":               fmt.Sprintf("%s %s", e.Sensor.Name, field),
+		"unique_id":          fmt.Sprintf("%s_%s", m.objectID(e), field),
+		"state_topic":        m.stateTopic(e),
+		"availability_topic": m.availabilityTopic(),
+		"device": map[string]interface{}{
+			"identifiers":  []string{m.objectID(e)},
+			"name":         e.Sensor.Name,
+			"model":        fmt.Sprintf("%s (%s)", e.Sensor.ModelID, e.Sensor.Type),
+			"manufacturer": e.Sensor.ManufacturerName,
+		},
+	}
+
+	if class, ok := haDeviceClasses[field]; ok {
+		config["device_class"] = class
+	}
+
+	if _, ok := value.(bool); ok {
+		config["value_template"] = fmt.Sprintf("{{ 'ON' if value_json['%s'] else 'OFF' }}", field)
+		return "binary_sensor", config
+	}
+
+	if _, ok := gaugeValue(value); !ok {
+		return "", nil
+	}
+
+	config["value_template"] = fmt.Sprintf("{{ value_json['%s'] }}", field)
+	config["state_class"] = "measurement"
+	if class, ok := haStateClasses[field]; ok {
+		config["state_class"] = class
+	}
+	if unit, ok := haUnits[field]; ok {
+		config["unit_of_measurement"] = unit
+	}
+
+	return "sensor", config
+}
+
+func (m *MQTT) stateTopic(e *deconz.SensorEvent) string {
+	return fmt.Sprintf("%s/%s/%d", m.topic(e.Gateway), e.Sensor.Type, e.Event.ID)
+}
+
+// topic returns the topic events from gateway are published below, ids are
+// only unique within a gateway
+func (m *MQTT) topic(gateway string) string {
+	if gateway == "" {
+		return m.config.Topic
+	}
+
+	return fmt.Sprintf("%s/%s", m.config.Topic, gateway)
+}
+
+func (m *MQTT) availabilityTopic() string {
+	return fmt.Sprintf("%s/status", m.config.Topic)
+}
+
+// objectID identifies the sensor towards Home Assistant, the deCONZ uniqueid
+// is preferred as it survives the sensor being paired again
+func (m *MQTT) objectID(e *deconz.SensorEvent) string {
+	id := e.Sensor.UniqueID
+	if id == "" {
+		id = strconv.Itoa(e.Event.ID)
+		if e.Gateway != "" {
+			id = fmt.Sprintf("%s_%d", e.Gateway, e.Event.ID)
+		}
+	}
+
+	return fmt.Sprintf("deflux_%s", haInvalidChars.ReplaceAllString(id, "_"))
+}
+
+// wait waits for the broker to acknowledge t
+func wait(t mqtt.Token) error {
+	if !t.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("timeout after %s", mqttTimeout)
+	}
+
+	return t.Error()
+}
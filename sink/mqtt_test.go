@@ -0,0 +1,174 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fasmide/deflux/deconz"
+	"github.com/fasmide/deflux/deconz/event"
+)
+
+// message is a message published to the fake broker
+type message struct {
+	topic    string
+	payload  []byte
+	retained bool
+}
+
+// fakeBroker accepts a single client and speaks just enough MQTT 3.1.1
+// to let it connect and publish
+func fakeBroker(t *testing.T) (string, chan message) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+
+	messages := make(chan message, 100)
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			header, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+
+			// remaining length is a variable length integer
+			var length, multiplier int = 0, 1
+			for {
+				b, err := r.ReadByte()
+				if err != nil {
+					return
+				}
+				length += int(b&127) * multiplier
+				multiplier *= 128
+				if b&128 == 0 {
+					break
+				}
+			}
+
+			body := make([]byte, length)
+			_, err = io.ReadFull(r, body)
+			if err != nil {
+				return
+			}
+
+			switch header >> 4 {
+			case 1: // CONNECT
+				conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+			case 3: // PUBLISH
+				topicLength := int(binary.BigEndian.Uint16(body))
+				m := message{topic: string(body[2 : 2+topicLength]), retained: header&1 == 1}
+				rest := body[2+topicLength:]
+				if qos := (header >> 1) & 3; qos > 0 {
+					conn.Write([]byte{0x40, 0x02, rest[0], rest[1]})
+					rest = rest[2:]
+				}
+				m.payload = rest
+				messages <- m
+			case 12: // PINGREQ
+				conn.Write([]byte{0xd0, 0x00})
+			case 14: // DISCONNECT
+				return
+			}
+		}
+	}()
+
+	return "tcp://" + l.Addr().String(), messages
+}
+
+func TestMQTT(t *testing.T) {
+	addr, messages := fakeBroker(t)
+
+	m, err := NewMQTT(MQTTConfig{Broker: addr, Discovery: "homeassistant"})
+	if err != nil {
+		t.Fatalf("unable to create mqtt sink: %s", err)
+	}
+	defer m.Close()
+
+	err = m.Add(testEvent())
+	if err != nil {
+		t.Fatalf("unable to add event: %s", err)
+	}
+
+	published := make(map[string]message)
+	timeout := time.After(5 * time.Second)
+	for published["deflux/ZHATemperature/1"].topic == "" {
+		select {
+		case msg := <-messages:
+			published[msg.topic] = msg
+		case <-timeout:
+			t.Fatalf("state was never published, got %v", published)
+		}
+	}
+
+	discovery, found := published["homeassistant/sensor/deflux_1/temperature/config"]
+	if !found || !discovery.retained {
+		t.Fatalf("no retained discovery config was published, got %v", published)
+	}
+
+	var config map[string]interface{}
+	err = json.Unmarshal(discovery.payload, &config)
+	if err != nil {
+		t.Fatalf("unable to unmarshal discovery config: %s", err)
+	}
+	if config["state_topic"] != "deflux/ZHATemperature/1" || config["device_class"] != "temperature" {
+		t.Errorf("unexpected discovery config: %s", discovery.payload)
+	}
+
+	var state map[string]interface{}
+	err = json.Unmarshal(published["deflux/ZHATemperature/1"].payload, &state)
+	if err != nil {
+		t.Fatalf("unable to unmarshal state: %s", err)
+	}
+	if state["temperature"] != 20.62 || state["lastupdated"] != "2018-03-08T19:35:24Z" {
+		t.Errorf("unexpected state: %v", state)
+	}
+}
+
+func TestMQTTAnnounceNewFields(t *testing.T) {
+	addr, messages := fakeBroker(t)
+
+	m, err := NewMQTT(MQTTConfig{Broker: addr, Discovery: "homeassistant"})
+	if err != nil {
+		t.Fatalf("unable to create mqtt sink: %s", err)
+	}
+	defer m.Close()
+
+	// a rotary dimmer first reports a button press, later an angle
+	angle := 90
+	sensor := &deconz.Sensor{Name: "Dimmer", Type: "ZHASwitch", UniqueID: "dimmer"}
+	for _, state := range []*event.ZHASwitch{{Buttonevent: 1002}, {Angle: &angle}} {
+		err = m.Add(&deconz.SensorEvent{Sensor: sensor, Event: &event.Event{ID: 7, State: state}})
+		if err != nil {
+			t.Fatalf("unable to add event: %s", err)
+		}
+	}
+
+	announced := make(map[string]int)
+	timeout := time.After(5 * time.Second)
+	for announced["homeassistant/sensor/deflux_dimmer/angle/config"] == 0 {
+		select {
+		case msg := <-messages:
+			announced[msg.topic]++
+		case <-timeout:
+			t.Fatalf("angle was never announced, got %v", announced)
+		}
+	}
+
+	if announced["homeassistant/sensor/deflux_dimmer/button/config"] != 1 {
+		t.Errorf("fields should only be announced once, got %v", announced)
+	}
+}
@@ -0,0 +1,347 @@
+package sink
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fasmide/deflux/deconz"
+)
+
+// PrometheusConfig holds the configuration of the prometheus exporter
+type PrometheusConfig struct {
+	// Listen is the address /metrics is served on, it defaults to :9110
+	Listen string
+	// Prune is how often series from sensors, lights and groups deCONZ no
+	// longer knows about are dropped, it defaults to 5 minutes
+	Prune time.Duration
+}
+
+// Prometheus keeps the latest value of every sensor field and exposes
+// them as gauges in the prometheus text format
+type Prometheus struct {
+	sensors map[string]deconz.SensorGetter
+	server  *http.Server
+	stop    chan struct{}
+
+	// mu guards series, which are keyed by identity
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a single gauge
+type series struct {
+	metric string
+	labels map[string]string
+	// resource, id and gateway is the sensor, light or group the series
+	// belongs to, series without a resource are never pruned
+	resource string
+	id       int
+	gateway  string
+	value    float64
+}
+
+// invalidMetricChars matches characters not allowed in prometheus metric names
+var invalidMetricChars = regexp.MustCompile("[^a-zA-Z0-9_:]")
+
+// NewPrometheus creates a Prometheus sink serving /metrics on c.Listen, sensors
+// of every gateway is used to find series belonging to sensors that has
+// disappeared. Lights and groups are pruned as well if the getter of a
+// gateway is also a LightGetter and GroupGetter
+func NewPrometheus(c PrometheusConfig, sensors map[string]deconz.SensorGetter) (*Prometheus, error) {
+	if c.Listen == "" {
+		c.Listen = ":9110"
+	}
+	if c.Prune == 0 {
+		c.Prune = 5 * time.Minute
+	}
+
+	// listen before returning, a port already in use should fail the sink
+	listener, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create prometheus sink: %s", err)
+	}
+
+	p := &Prometheus{
+		sensors: sensors,
+		stop:    make(chan struct{}),
+		series:  make(map[string]*series),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", p)
+	p.server = &http.Server{Handler: mux}
+
+	go func() {
+		err := p.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("prometheus exporter stopped: %s", err)
+		}
+	}()
+
+	go p.pruneEvery(c.Prune)
+
+	return p, nil
+}
+
+// Add updates the gauges of every numeric and boolean field in the event.
+// Sensor config fields are exposed with a deflux_config_ prefix while
+// light and group fields are prefixed with their measurement name
+func (p *Prometheus) Add(e deconz.ResourceEvent) error {
+	se, ok := e.(*deconz.SensorEvent)
+	if !ok {
+		measurements, err := e.Measurements()
+		if err != nil {
+			return err
+		}
+
+		// scenes are never pruned, they only have text
+		var resource, gateway string
+		var id int
+		switch t := e.(type) {
+		case *deconz.LightEvent:
+			resource, id, gateway = "lights", t.Event.ID, t.Gateway
+		case *deconz.GroupEvent:
+			resource, id, gateway = "groups", t.Event.ID, t.Gateway
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		for _, m := range measurements {
+			p.set(resource, id, gateway, m.Tags, m.Name+"_", m.Fields)
+		}
+		return nil
+	}
+
+	// config-only events have no state and so no labels from Timeseries
+	labels, state, stateErr := se.Timeseries()
+	configLabels, config, configErr := se.ConfigTimeseries()
+	if stateErr != nil && configErr != nil {
+		return stateErr
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.set("sensors", se.Event.ID, se.Gateway, labels, "deflux_", state)
+	p.set("sensors", se.Event.ID, se.Gateway, configLabels, "deflux_config_", config)
+
+	return nil
+}
+
+// set updates a gauge for every field, series are pruned once resource id
+// disappears from the gateway. p.mu must be held
+func (p *Prometheus) set(resource string, id int, gateway string, labels map[string]string, prefix string, fields map[string]interface{}) {
+	for field, v := range fields {
+		value, ok := gaugeValue(v)
+		if !ok {
+			continue
+		}
+
+		s := &series{
+			metric:   prefix + invalidMetricChars.ReplaceAllString(field, "_"),
+			labels:   labels,
+			resource: resource,
+			id:       id,
+			gateway:  gateway,
+			value:    value,
+		}
+		p.series[s.identity()] = s
+	}
+}
+
+// ConnectionState exposes the state of the connection to a gateway as deflux_connected
+func (p *Prometheus) ConnectionState(gateway string, state deconz.ConnectionState) {
+	var value float64
+	if state == deconz.Connected {
+		value = 1
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &series{metric: "deflux_connected", labels: map[string]string{}, value: value}
+	if gateway != "" {
+		s.labels["gateway"] = gateway
+	}
+	p.series[s.key()] = s
+}
+
+// Close stops the http server and pruning
+func (p *Prometheus) Close() error {
+	close(p.stop)
+	return p.server.Close()
+}
+
+// ServeHTTP writes every gauge in the prometheus text format
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	all := make([]*series, 0, len(p.series))
+	for _, s := range p.series {
+		all = append(all, s)
+	}
+	p.mu.Unlock()
+
+	// sort by key which also groups series by metric
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].key() < all[j].key()
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	var metric string
+	for _, s := range all {
+		if s.metric != metric {
+			metric = s.metric
+			fmt.Fprintf(w, "# TYPE %s gauge\n", metric)
+		}
+		fmt.Fprintf(w, "%s %s\n", s.key(), strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+func (p *Prometheus) pruneEvery(d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.prune()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// prune drops series from sensors, lights and groups deCONZ no longer knows
+// about, gateways that cannot be asked are logged and skipped
+func (p *Prometheus) prune() {
+	// ids by gateway and resource
+	known := make(map[string]map[string]map[int]bool, len(p.sensors))
+	for gateway, getter := range p.sensors {
+		ids, err := knownIDs(getter)
+		if err != nil {
+			log.Printf("unable to prune prometheus series of gateway %q: %s", gateway, err)
+			continue
+		}
+		known[gateway] = ids
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for k, s := range p.series {
+		if s.resource == "" {
+			continue
+		}
+
+		// resources of gateways that cannot be asked are kept
+		ids, found := known[s.gateway][s.resource]
+		if !found {
+			continue
+		}
+		if !ids[s.id] {
+			delete(p.series, k)
+		}
+	}
+}
+
+// knownIDs fetches the ids of sensors, and of lights and groups if getter
+// can fetch them
+func knownIDs(getter deconz.SensorGetter) (map[string]map[int]bool, error) {
+	ids := make(map[string]map[int]bool)
+
+	sensors, err := getter.Sensors()
+	if err != nil {
+		return nil, err
+	}
+	ids["sensors"] = make(map[int]bool, len(*sensors))
+	for id := range *sensors {
+		ids["sensors"][id] = true
+	}
+
+	if lg, ok := getter.(deconz.LightGetter); ok {
+		lights, err := lg.Lights()
+		if err != nil {
+			return nil, err
+		}
+		ids["lights"] = make(map[int]bool, len(*lights))
+		for id := range *lights {
+			ids["lights"][id] = true
+		}
+	}
+
+	if gg, ok := getter.(deconz.GroupGetter); ok {
+		groups, err := gg.Groups()
+		if err != nil {
+			return nil, err
+		}
+		ids["groups"] = make(map[int]bool, len(*groups))
+		for id := range *groups {
+			ids["groups"][id] = true
+		}
+	}
+
+	return ids, nil
+}
+
+// identity identifies the series, series of a resource are identified by
+// the resource rather than their labels so renaming it replaces its series
+func (s *series) identity() string {
+	if s.resource == "" {
+		return s.key()
+	}
+
+	return fmt.Sprintf("%s/%s/%d/%s", s.gateway, s.resource, s.id, s.metric)
+}
+
+// key returns the metric name and its labels in the prometheus text format
+func (s *series) key() string {
+	names := make([]string, 0, len(s.labels))
+	for name := range s.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	labels := make([]string, 0, len(names))
+	for _, name := range names {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", name, labelEscaper.Replace(s.labels[name])))
+	}
+
+	return fmt.Sprintf("%s{%s}", s.metric, strings.Join(labels, ","))
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+// gaugeValue converts field values into gauge values, booleans are 0 or 1
+func gaugeValue(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case bool:
+		if t {
+			return 1, true
+		}
+		return 0, true
+	case int:
+		return float64(t), true
+	case int16:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case float32:
+		return float64(t), true
+	case float64:
+		return t, true
+	default:
+		return 0, false
+	}
+}
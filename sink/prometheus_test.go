@@ -0,0 +1,246 @@
+package sink
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fasmide/deflux/deconz"
+	"github.com/fasmide/deflux/deconz/event"
+)
+
+type testSensors deconz.Sensors
+
+func (t testSensors) Sensors() (*deconz.Sensors, error) {
+	s := deconz.Sensors(t)
+	return &s, nil
+}
+
+// unreachableSensors is a gateway that cannot be asked for sensors
+type unreachableSensors struct{}
+
+func (unreachableSensors) Sensors() (*deconz.Sensors, error) {
+	return nil, errors.New("connection refused")
+}
+
+func scrape(p *Prometheus) string {
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestPrometheus(t *testing.T) {
+	p, err := NewPrometheus(PrometheusConfig{Listen: "127.0.0.1:0"}, map[string]deconz.SensorGetter{"": testSensors{}})
+	if err != nil {
+		t.Logf("unable to create prometheus sink: %s", err)
+		t.FailNow()
+	}
+	defer p.Close()
+
+	p.Add(testEvent())
+	p.Add(&deconz.SensorEvent{
+		Sensor: &deconz.Sensor{Name: "Smoke", Type: "ZHAFire"},
+		Event:  &event.Event{ID: 5, State: &event.ZHAFire{Fire: true}},
+	})
+
+	metrics := scrape(p)
+	for _, line := range []string{
+		"# TYPE deflux_temperature gauge\n",
+		"deflux_temperature{id=\"1\",name=\"Test Sensor\",type=\"ZHATemperature\"} 20.62\n",
+		"deflux_fire{id=\"5\",name=\"Smoke\",type=\"ZHAFire\"} 1\n",
+		"deflux_tampered{id=\"5\",name=\"Smoke\",type=\"ZHAFire\"} 0\n",
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("missing %q in:\n%s", line, metrics)
+		}
+	}
+
+	// only sensor 1 is still known to deCONZ
+	p.sensors[""] = testSensors{1: deconz.Sensor{Name: "Test Sensor", Type: "ZHATemperature"}}
+	p.prune()
+
+	metrics = scrape(p)
+	if strings.Contains(metrics, "deflux_fire") {
+		t.Errorf("series from removed sensor was not pruned:\n%s", metrics)
+	}
+	if !strings.Contains(metrics, "deflux_temperature") {
+		t.Errorf("series from known sensor was pruned:\n%s", metrics)
+	}
+}
+
+func TestPrometheusGateways(t *testing.T) {
+	attic := deconz.Sensors{1: deconz.Sensor{Name: "Attic", Type: "ZHATemperature"}}
+	p, err := NewPrometheus(PrometheusConfig{Listen: "127.0.0.1:0"}, map[string]deconz.SensorGetter{
+		"house":  testSensors(attic),
+		"garage": testSensors{},
+	})
+	if err != nil {
+		t.Fatalf("unable to create prometheus sink: %s", err)
+	}
+	defer p.Close()
+
+	// sensor 1 exists on both gateways, but only the house still has it
+	for _, gateway := range []string{"house", "garage"} {
+		e := testEvent()
+		e.Gateway = gateway
+		p.Add(e)
+	}
+	p.ConnectionState("garage", deconz.Connected)
+
+	p.prune()
+
+	metrics := scrape(p)
+	for _, line := range []string{
+		"deflux_temperature{gateway=\"house\",id=\"1\",name=\"Test Sensor\",type=\"ZHATemperature\"} 20.62\n",
+		"deflux_connected{gateway=\"garage\"} 1\n",
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("missing %q in:\n%s", line, metrics)
+		}
+	}
+	if strings.Contains(metrics, "gateway=\"garage\",id=\"1\"") {
+		t.Errorf("series from the sensor removed from the garage was not pruned:\n%s", metrics)
+	}
+}
+
+func TestPrometheusPruneUnreachable(t *testing.T) {
+	p, err := NewPrometheus(PrometheusConfig{Listen: "127.0.0.1:0"}, map[string]deconz.SensorGetter{
+		"house":  testSensors{},
+		"garage": unreachableSensors{},
+	})
+	if err != nil {
+		t.Fatalf("unable to create prometheus sink: %s", err)
+	}
+	defer p.Close()
+
+	for _, gateway := range []string{"house", "garage"} {
+		e := testEvent()
+		e.Gateway = gateway
+		p.Add(e)
+	}
+
+	// the garage cannot be asked, the house should be pruned regardless
+	p.prune()
+
+	metrics := scrape(p)
+	if strings.Contains(metrics, "gateway=\"house\"") {
+		t.Errorf("series from the sensor removed from the house was not pruned:\n%s", metrics)
+	}
+	if !strings.Contains(metrics, "gateway=\"garage\"") {
+		t.Errorf("series from the unreachable garage was pruned:\n%s", metrics)
+	}
+}
+
+func TestPrometheusConfigOnly(t *testing.T) {
+	p, err := NewPrometheus(PrometheusConfig{Listen: "127.0.0.1:0"}, map[string]deconz.SensorGetter{"": testSensors{}})
+	if err != nil {
+		t.Fatalf("unable to create prometheus sink: %s", err)
+	}
+	defer p.Close()
+
+	// battery updates arrive as config without state
+	for id, battery := range map[int]int{1: 100, 2: 50} {
+		battery := battery
+		err = p.Add(&deconz.SensorEvent{
+			Sensor: &deconz.Sensor{Name: "Door", Type: "ZHAOpenClose"},
+			Event:  &event.Event{ID: id, State: &event.EmptyState{}, Config: &event.Config{Battery: &battery}},
+		})
+		if err != nil {
+			t.Fatalf("unable to add config event: %s", err)
+		}
+	}
+
+	metrics := scrape(p)
+	for _, line := range []string{
+		"deflux_config_battery{id=\"1\",name=\"Door\",type=\"ZHAOpenClose\"} 100\n",
+		"deflux_config_battery{id=\"2\",name=\"Door\",type=\"ZHAOpenClose\"} 50\n",
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("missing %q in:\n%s", line, metrics)
+		}
+	}
+}
+
+func TestPrometheusListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	defer l.Close()
+
+	// the address is already in use
+	_, err = NewPrometheus(PrometheusConfig{Listen: l.Addr().String()}, map[string]deconz.SensorGetter{"": testSensors{}})
+	if err == nil {
+		t.Errorf("listening on an address in use should fail")
+	}
+}
+
+// testGateway knows sensors, lights and groups
+type testGateway struct {
+	testSensors
+	lights deconz.Lights
+	groups deconz.Groups
+}
+
+func (t testGateway) Lights() (*deconz.Lights, error) {
+	return &t.lights, nil
+}
+
+func (t testGateway) Groups() (*deconz.Groups, error) {
+	return &t.groups, nil
+}
+
+func TestPrometheusPruneLights(t *testing.T) {
+	p, err := NewPrometheus(PrometheusConfig{Listen: "127.0.0.1:0"}, map[string]deconz.SensorGetter{"": testGateway{}})
+	if err != nil {
+		t.Fatalf("unable to create prometheus sink: %s", err)
+	}
+	defer p.Close()
+
+	on := true
+	p.Add(&deconz.LightEvent{
+		Light: &deconz.Light{Name: "Kitchen", Type: "Extended color light"},
+		Event: &event.Event{ID: 2, State: &event.LightState{On: &on}},
+	})
+	p.Add(&deconz.GroupEvent{
+		Group: &deconz.Group{Name: "Kitchen", Type: "LightGroup"},
+		Event: &event.Event{ID: 4, State: &event.GroupState{AnyOn: &on}},
+	})
+
+	// the light was removed, the group is still known to deCONZ
+	p.sensors[""] = testGateway{groups: deconz.Groups{4: deconz.Group{Name: "Kitchen"}}}
+	p.prune()
+
+	metrics := scrape(p)
+	if strings.Contains(metrics, "deflux_light_on") {
+		t.Errorf("series from removed light was not pruned:\n%s", metrics)
+	}
+	if !strings.Contains(metrics, "deflux_group_any_on") {
+		t.Errorf("series from known group was pruned:\n%s", metrics)
+	}
+}
+
+func TestPrometheusRename(t *testing.T) {
+	p, err := NewPrometheus(PrometheusConfig{Listen: "127.0.0.1:0"}, map[string]deconz.SensorGetter{"": testSensors{}})
+	if err != nil {
+		t.Fatalf("unable to create prometheus sink: %s", err)
+	}
+	defer p.Close()
+
+	p.Add(testEvent())
+
+	// the sensor is renamed in deCONZ
+	renamed := testEvent()
+	renamed.Sensor.Name = "Kitchen"
+	p.Add(renamed)
+
+	metrics := scrape(p)
+	if strings.Contains(metrics, "Test Sensor") {
+		t.Errorf("series with the old name was kept:\n%s", metrics)
+	}
+	if !strings.Contains(metrics, "deflux_temperature{id=\"1\",name=\"Kitchen\",type=\"ZHATemperature\"} 20.62\n") {
+		t.Errorf("series with the new name is missing:\n%s", metrics)
+	}
+}
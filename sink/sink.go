@@ -0,0 +1,108 @@
+package sink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fasmide/deflux/deconz"
+)
+
+// Sink is somewhere sensor, light and group events can be written to
+type Sink interface {
+	// Add hands an event to the sink, the sink may buffer it before writing
+	Add(deconz.ResourceEvent) error
+	// Close writes anything buffered and releases the sink
+	Close() error
+}
+
+// Config selects a sink by Type and holds the configuration for it
+type Config struct {
+	Type       string
+	Influxdb   *InfluxdbConfig   `yaml:",omitempty"`
+	Influxdb2  *Influxdb2Config  `yaml:",omitempty"`
+	Prometheus *PrometheusConfig `yaml:",omitempty"`
+	MQTT       *MQTTConfig       `yaml:",omitempty"`
+}
+
+// New creates the sink described by c, sensors is used by sinks that needs
+// to know which sensors every gateway currently has, indexed by gateway name
+func New(c Config, sensors map[string]deconz.SensorGetter) (Sink, error) {
+	switch c.Type {
+	case "influxdb":
+		if c.Influxdb == nil {
+			return nil, fmt.Errorf("sink type %s has no influxdb configuration", c.Type)
+		}
+		return NewInfluxdb(*c.Influxdb)
+	case "influxdb2":
+		if c.Influxdb2 == nil {
+			return nil, fmt.Errorf("sink type %s has no influxdb2 configuration", c.Type)
+		}
+		return NewInfluxdb2(*c.Influxdb2)
+	case "prometheus":
+		if c.Prometheus == nil {
+			return nil, fmt.Errorf("sink type %s has no prometheus configuration", c.Type)
+		}
+		return NewPrometheus(*c.Prometheus, sensors)
+	case "mqtt":
+		if c.MQTT == nil {
+			return nil, fmt.Errorf("sink type %s has no mqtt configuration", c.Type)
+		}
+		return NewMQTT(*c.MQTT)
+	default:
+		return nil, fmt.Errorf("unable to create sink: %s is not a known type", c.Type)
+	}
+}
+
+// Multi fans events out to multiple sinks
+type Multi []Sink
+
+// Add adds the event to every sink, a failing sink does not keep the event
+// from reaching the others
+func (m Multi) Add(e deconz.ResourceEvent) error {
+	var errs []string
+	for _, s := range m {
+		err := s.Add(e)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%T: %s", s, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d sinks failed: %s", len(errs), len(m), strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// connectionStater is implemented by sinks that expose the state of the
+// connection to deCONZ
+type connectionStater interface {
+	ConnectionState(string, deconz.ConnectionState)
+}
+
+// ConnectionState hands the state of the connection to a gateway to every
+// sink interested in it
+func (m Multi) ConnectionState(gateway string, s deconz.ConnectionState) {
+	for _, sink := range m {
+		if c, ok := sink.(connectionStater); ok {
+			c.ConnectionState(gateway, s)
+		}
+	}
+}
+
+// Close closes every sink
+func (m Multi) Close() error {
+	var errs []string
+	for _, s := range m {
+		err := s.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%T: %s", s, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to close %d of %d sinks: %s", len(errs), len(m), strings.Join(errs, ", "))
+	}
+
+	return nil
+}
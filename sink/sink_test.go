@@ -0,0 +1,250 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fasmide/deflux/deconz"
+	"github.com/fasmide/deflux/deconz/event"
+)
+
+// memory is an in memory sink
+type memory struct {
+	events []deconz.ResourceEvent
+	closed bool
+	err    error
+}
+
+func (m *memory) Add(e deconz.ResourceEvent) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.events = append(m.events, e)
+	return nil
+}
+
+func (m *memory) Close() error {
+	m.closed = true
+	return nil
+}
+
+func testEvent() *deconz.SensorEvent {
+	return &deconz.SensorEvent{
+		Sensor: &deconz.Sensor{Name: "Test Sensor", Type: "ZHATemperature"},
+		Event: &event.Event{
+			ID:    1,
+			State: &event.ZHATemperature{State: event.State{Lastupdated: "2018-03-08T19:35:24"}, Temperature: 2062},
+		},
+	}
+}
+
+func TestMulti(t *testing.T) {
+	a, b := &memory{}, &memory{}
+	m := Multi{a, b}
+
+	err := m.Add(testEvent())
+	if err != nil {
+		t.Logf("unable to add event: %s", err)
+		t.FailNow()
+	}
+
+	if len(a.events) != 1 || len(b.events) != 1 {
+		t.Fail()
+	}
+
+	m.Close()
+	if !a.closed || !b.closed {
+		t.Fail()
+	}
+}
+
+func TestMultiFailingSink(t *testing.T) {
+	a, b := &memory{err: errors.New("broken")}, &memory{}
+	m := Multi{a, b}
+
+	err := m.Add(testEvent())
+	if err == nil {
+		t.Fail()
+	}
+
+	// the working sink should still have gotten the event
+	if len(b.events) != 1 {
+		t.Fail()
+	}
+}
+
+func TestNewUnknownType(t *testing.T) {
+	_, err := New(Config{Type: "nosuchsink"}, nil)
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestPoints(t *testing.T) {
+	pts, err := points(testEvent())
+	if err != nil {
+		t.Logf("unable to create points: %s", err)
+		t.FailNow()
+	}
+
+	if len(pts) != 1 {
+		t.Fatalf("expected 1 point, got %d", len(pts))
+	}
+	pt := pts[0]
+
+	if pt.Name() != "deflux_ZHATemperature" {
+		t.Fail()
+	}
+
+	if pt.Tags()["name"] != "Test Sensor" || pt.Tags()["id"] != "1" {
+		t.Fail()
+	}
+
+	if !pt.Time().Equal(time.Date(2018, 3, 8, 19, 35, 24, 0, time.UTC)) {
+		t.Fail()
+	}
+}
+
+func TestConfigPoints(t *testing.T) {
+	battery := 100
+	received := time.Date(2018, 3, 29, 11, 58, 23, 0, time.UTC)
+
+	e := &deconz.SensorEvent{
+		Sensor:   &deconz.Sensor{Name: "lumi.sensor_wleak.aq1", Type: "ZHAWater"},
+		Event:    &event.Event{ID: 6, State: &event.EmptyState{}, Config: &event.Config{Battery: &battery}},
+		Received: received,
+	}
+
+	pts, err := points(e)
+	if err != nil {
+		t.Fatalf("unable to create points: %s", err)
+	}
+
+	if len(pts) != 1 || pts[0].Name() != "deflux_config" {
+		t.Fatalf("expected a single deflux_config point, got %v", pts)
+	}
+
+	fields, _ := pts[0].Fields()
+	if fields["battery"] != int64(100) || pts[0].Tags()["type"] != "ZHAWater" {
+		t.Errorf("unexpected point %s", pts[0])
+	}
+
+	if !pts[0].Time().Equal(received) {
+		t.Errorf("config point should use the receive time, got %s", pts[0].Time())
+	}
+}
+
+func TestLightPoints(t *testing.T) {
+	on := true
+	bri := 180
+	received := time.Date(2018, 3, 29, 11, 58, 23, 0, time.UTC)
+
+	e := &deconz.LightEvent{
+		Light:    &deconz.Light{Name: "Kitchen", Type: "Dimmable light"},
+		Event:    &event.Event{ID: 4, Resource: "lights", State: &event.LightState{On: &on, Bri: &bri}},
+		Received: received,
+	}
+
+	pts, err := points(e)
+	if err != nil {
+		t.Fatalf("unable to create points: %s", err)
+	}
+
+	if len(pts) != 1 || pts[0].Name() != "deflux_light" {
+		t.Fatalf("expected a single deflux_light point, got %v", pts)
+	}
+
+	fields, _ := pts[0].Fields()
+	if fields["on"] != true || fields["bri"] != int64(180) || pts[0].Tags()["id"] != "4" {
+		t.Errorf("unexpected point %s", pts[0])
+	}
+
+	if !pts[0].Time().Equal(received) {
+		t.Errorf("light point should use the receive time, got %s", pts[0].Time())
+	}
+}
+
+func TestScenePoints(t *testing.T) {
+	e := &deconz.SceneEvent{
+		Group:    &deconz.Group{Name: "Living room"},
+		Scene:    &deconz.Scene{ID: 2, Name: "Movie"},
+		Event:    &event.Event{Resource: "scenes", Event: "scene-called", GroupID: 3, SceneID: 2},
+		Received: time.Date(2018, 3, 29, 11, 58, 23, 0, time.UTC),
+	}
+
+	pts, err := points(e)
+	if err != nil {
+		t.Fatalf("unable to create points: %s", err)
+	}
+
+	if len(pts) != 1 || pts[0].Name() != "deflux_scene" {
+		t.Fatalf("expected a single deflux_scene point, got %v", pts)
+	}
+
+	tags := pts[0].Tags()
+	if tags["group"] != "Living room" || tags["scene"] != "Movie" || tags["group_id"] != "3" || tags["scene_id"] != "2" {
+		t.Errorf("unexpected point %s", pts[0])
+	}
+}
+
+func TestVirtualPoints(t *testing.T) {
+	e := &deconz.SensorEvent{
+		Sensor: &deconz.Sensor{Name: "Away mode", Type: "CLIPGenericFlag"},
+		Event:  &event.Event{ID: 30, State: &event.CLIPGenericFlag{State: event.State{Lastupdated: "2022-03-01T06:30:00"}, Flag: true}},
+	}
+
+	pts, err := points(e)
+	if err != nil {
+		t.Fatalf("unable to create points: %s", err)
+	}
+
+	if pts[0].Tags()["virtual"] != "true" {
+		t.Errorf("clip sensors should be tagged as virtual: %s", pts[0])
+	}
+
+	pts, err = points(testEvent())
+	if err != nil {
+		t.Fatalf("unable to create points: %s", err)
+	}
+
+	if _, found := pts[0].Tags()["virtual"]; found {
+		t.Errorf("physical sensors should not be tagged as virtual: %s", pts[0])
+	}
+}
+
+func TestGatewayPoints(t *testing.T) {
+	e := testEvent()
+	e.Gateway = "north"
+
+	pts, err := points(e)
+	if err != nil {
+		t.Fatalf("unable to create points: %s", err)
+	}
+
+	if pts[0].Tags()["gateway"] != "north" {
+		t.Errorf("points should be tagged with their gateway: %s", pts[0])
+	}
+
+	pts, err = points(testEvent())
+	if err != nil {
+		t.Fatalf("unable to create points: %s", err)
+	}
+
+	if _, found := pts[0].Tags()["gateway"]; found {
+		t.Errorf("points from unnamed gateways should not be tagged: %s", pts[0])
+	}
+}
+
+func TestEmptyGenericPoints(t *testing.T) {
+	e := &deconz.SensorEvent{
+		Sensor: &deconz.Sensor{Name: "Unknown", Type: "ZHAUnknown"},
+		Event:  &event.Event{ID: 8, State: &event.GenericState{Values: map[string]interface{}{}}},
+	}
+
+	// a point without fields would be refused by influxdb
+	_, err := points(e)
+	if err == nil {
+		t.Errorf("generic states without fields should not become points")
+	}
+}
@@ -0,0 +1,348 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+// spool backoff between replay attempts, doubled on every failure
+var (
+	spoolMinBackoff = 1 * time.Second
+	spoolMaxBackoff = 5 * time.Minute
+)
+
+// SpoolConfig configures the on disk spool batches are written to when
+// they cannot be delivered
+type SpoolConfig struct {
+	// Dir is where spooled batches are kept, spooling is disabled when empty
+	Dir string
+	// MaxSize is the maximum number of bytes kept in the spool, 0 means no limit
+	MaxSize int64
+	// MaxAge is how long batches are kept in the spool, 0 means forever
+	MaxAge time.Duration
+	// Drop is either oldest or newest and decides which batches are dropped
+	// when the spool is full, it defaults to oldest
+	Drop string
+}
+
+// spoolFile is a single batch in the spool
+type spoolFile struct {
+	name    string
+	size    int64
+	created time.Time
+}
+
+// spool is an append only queue of batches on disk, every batch is a
+// file of line protocol named after when it was spooled. Batches are
+// replayed in order in the background until write succeeds, or influxdb
+// rejects them and they are moved to the rejected directory
+type spool struct {
+	config SpoolConfig
+	write  func([]*client.Point) error
+
+	mu    sync.Mutex
+	files []spoolFile
+	last  int64
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newSpool opens the spool in c.Dir and starts replaying anything left in it
+func newSpool(c SpoolConfig, write func([]*client.Point) error) (*spool, error) {
+	switch c.Drop {
+	case "":
+		c.Drop = "oldest"
+	case "oldest", "newest":
+	default:
+		return nil, fmt.Errorf("unable to open spool: %s is not a known drop policy", c.Drop)
+	}
+
+	err := os.MkdirAll(c.Dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create spool directory: %s", err)
+	}
+
+	s := &spool{
+		config: c,
+		write:  write,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.files) > 0 {
+		log.Printf("Found %d spooled batches in %s", len(s.files), c.Dir)
+		s.wake <- struct{}{}
+	}
+
+	go s.replay()
+
+	return s, nil
+}
+
+// load finds batches left by a previous run
+func (s *spool) load() error {
+	infos, err := ioutil.ReadDir(s.config.Dir)
+	if err != nil {
+		return fmt.Errorf("unable to read spool directory: %s", err)
+	}
+
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != ".lp" {
+			continue
+		}
+
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(info.Name(), ".lp"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s.files = append(s.files, spoolFile{name: info.Name(), size: info.Size(), created: time.Unix(0, nanos)})
+		if nanos > s.last {
+			s.last = nanos
+		}
+	}
+
+	// ReadDir sorts by name and names are zero padded, but be explicit about it
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].name < s.files[j].name })
+
+	return nil
+}
+
+// empty reports whether there is nothing waiting to be replayed
+func (s *spool) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.files) == 0
+}
+
+// add appends a batch to the spool, dropping batches if the spool is full.
+// It returns an error if the new batch is the one dropped
+func (s *spool) add(points []*client.Point) error {
+	var buf bytes.Buffer
+	for _, pt := range points {
+		buf.WriteString(pt.String())
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+
+	size := int64(buf.Len())
+	if s.config.MaxSize > 0 {
+		if size > s.config.MaxSize || (s.config.Drop == "newest" && s.size()+size > s.config.MaxSize) {
+			return fmt.Errorf("spool %s is full", s.config.Dir)
+		}
+
+		for s.size()+size > s.config.MaxSize {
+			s.remove(fmt.Sprintf("spool %s is full", s.config.Dir))
+		}
+	}
+
+	// names must be unique and sort in the order batches was added
+	nanos := time.Now().UnixNano()
+	if nanos <= s.last {
+		nanos = s.last + 1
+	}
+	s.last = nanos
+
+	f := spoolFile{name: fmt.Sprintf("%020d.lp", nanos), size: size, created: time.Unix(0, nanos)}
+
+	// write to a temporary file first, a crash should never leave half a batch behind
+	tmp := filepath.Join(s.config.Dir, f.name+".tmp")
+	err := ioutil.WriteFile(tmp, buf.Bytes(), 0600)
+	if err != nil {
+		return fmt.Errorf("unable to spool batch: %s", err)
+	}
+
+	err = os.Rename(tmp, filepath.Join(s.config.Dir, f.name))
+	if err != nil {
+		return fmt.Errorf("unable to spool batch: %s", err)
+	}
+
+	s.files = append(s.files, f)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// close stops replaying, anything not yet replayed stays on disk
+func (s *spool) close() {
+	close(s.stop)
+	<-s.done
+}
+
+// replay writes spooled batches in order, backing off while write fails.
+// Batches influxdb rejects are quarantined instead
+func (s *spool) replay() {
+	defer close(s.done)
+
+	backoff := spoolMinBackoff
+	for {
+		select {
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+
+		for !s.empty() {
+			err := s.replayOldest()
+			if err == nil {
+				backoff = spoolMinBackoff
+				continue
+			}
+
+			log.Printf("Unable to replay spooled batch, retrying in %s: %s", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-s.stop:
+				return
+			}
+
+			backoff *= 2
+			if backoff > spoolMaxBackoff {
+				backoff = spoolMaxBackoff
+			}
+		}
+	}
+}
+
+// replayOldest writes the oldest batch and removes it from the spool
+func (s *spool) replayOldest() error {
+	s.mu.Lock()
+	s.expire()
+	if len(s.files) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	f := s.files[0]
+	s.mu.Unlock()
+
+	data, err := ioutil.ReadFile(filepath.Join(s.config.Dir, f.name))
+	if err != nil {
+		s.drop(f, fmt.Sprintf("unable to read it: %s", err))
+		return nil
+	}
+
+	parsed, err := models.ParsePoints(data)
+	if err != nil {
+		s.drop(f, fmt.Sprintf("unable to parse it: %s", err))
+		return nil
+	}
+
+	points := make([]*client.Point, 0, len(parsed))
+	for _, pt := range parsed {
+		points = append(points, client.NewPointFrom(pt))
+	}
+
+	// batches influxdb refuses would block every batch behind them until they expire
+	err = s.write(points)
+	if rejected(err) {
+		s.quarantine(f, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the oldest batch could have expired or been dropped while we wrote it
+	if len(s.files) > 0 && s.files[0].name == f.name {
+		os.Remove(filepath.Join(s.config.Dir, f.name))
+		s.files = s.files[1:]
+	}
+
+	log.Printf("Replayed %d spooled points, %d batches left", len(points), len(s.files))
+	return nil
+}
+
+// drop removes f if it is still the oldest batch
+func (s *spool) drop(f spoolFile, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.files) > 0 && s.files[0].name == f.name {
+		s.remove(reason)
+	}
+}
+
+// quarantine moves f out of the queue into the rejected directory, if it is
+// still the oldest batch, where it is kept for inspection
+func (s *spool) quarantine(f spoolFile, reason error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.files) == 0 || s.files[0].name != f.name {
+		return
+	}
+
+	dir := filepath.Join(s.config.Dir, "rejected")
+	err := os.MkdirAll(dir, 0700)
+	if err == nil {
+		err = os.Rename(filepath.Join(s.config.Dir, f.name), filepath.Join(dir, f.name))
+	}
+	if err != nil {
+		s.remove(fmt.Sprintf("it was rejected and could not be quarantined: %s: %s", err, reason))
+		return
+	}
+
+	s.files = s.files[1:]
+	log.Printf("Quarantined rejected spooled batch %s in %s: %s", f.name, dir, reason)
+}
+
+// expire drops batches older than MaxAge, s.mu must be held
+func (s *spool) expire() {
+	if s.config.MaxAge == 0 {
+		return
+	}
+
+	for len(s.files) > 0 && time.Since(s.files[0].created) > s.config.MaxAge {
+		s.remove(fmt.Sprintf("it is older than %s", s.config.MaxAge))
+	}
+}
+
+// remove drops the oldest batch, s.mu must be held
+func (s *spool) remove(reason string) {
+	f := s.files[0]
+	os.Remove(filepath.Join(s.config.Dir, f.name))
+	s.files = s.files[1:]
+
+	log.Printf("Dropping spooled batch %s: %s", f.name, reason)
+}
+
+// size returns the number of bytes spooled, s.mu must be held
+func (s *spool) size() int64 {
+	var size int64
+	for _, f := range s.files {
+		size += f.size
+	}
+	return size
+}
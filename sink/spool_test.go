@@ -0,0 +1,195 @@
+package sink
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+// flakyWriter fails until it is fixed and remembers what it wrote
+type flakyWriter struct {
+	sync.Mutex
+	broken  bool
+	written []string
+}
+
+func (f *flakyWriter) write(points []*client.Point) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.broken {
+		return errors.New("influxdb is down")
+	}
+
+	for _, pt := range points {
+		f.written = append(f.written, pt.Tags()["id"])
+	}
+	return nil
+}
+
+func testPoint(id string) *client.Point {
+	pt, _ := client.NewPoint("deflux_test", map[string]string{"id": id}, map[string]interface{}{"value": 1}, time.Unix(1520537724, 0))
+	return pt
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deflux-spool")
+	if err != nil {
+		t.Fatalf("unable to create spool dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(d time.Duration) { spoolMinBackoff = d }(spoolMinBackoff)
+	spoolMinBackoff = 10 * time.Millisecond
+
+	w := &flakyWriter{broken: true}
+	s, err := newSpool(SpoolConfig{Dir: dir}, w.write)
+	if err != nil {
+		t.Fatalf("unable to open spool: %s", err)
+	}
+
+	s.add([]*client.Point{testPoint("1"), testPoint("2")})
+	s.add([]*client.Point{testPoint("3")})
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("expected 2 spooled batches, found %d", len(files))
+	}
+
+	w.Lock()
+	w.broken = false
+	w.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.empty() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.close()
+
+	if len(w.written) != 3 || w.written[0] != "1" || w.written[1] != "2" || w.written[2] != "3" {
+		t.Errorf("batches was not replayed in order: %v", w.written)
+	}
+
+	files, _ = ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("replayed batches was not removed, found %d", len(files))
+	}
+}
+
+func TestSpoolDropOldest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deflux-spool")
+	if err != nil {
+		t.Fatalf("unable to create spool dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := &flakyWriter{broken: true}
+	s, err := newSpool(SpoolConfig{Dir: dir, MaxSize: 100}, w.write)
+	if err != nil {
+		t.Fatalf("unable to open spool: %s", err)
+	}
+
+	// every batch is a little above 40 bytes, only two fit
+	for _, id := range []string{"1", "2", "3"} {
+		s.add([]*client.Point{testPoint(id)})
+	}
+	s.close()
+
+	// a new spool should pick up what is left on disk
+	s, err = newSpool(SpoolConfig{Dir: dir}, w.write)
+	if err != nil {
+		t.Fatalf("unable to open spool: %s", err)
+	}
+	defer s.close()
+
+	if len(s.files) != 2 {
+		t.Fatalf("expected 2 spooled batches, found %d", len(s.files))
+	}
+
+	data, _ := ioutil.ReadFile(dir + "/" + s.files[0].name)
+	if string(data) != testPoint("2").String()+"\n" {
+		t.Errorf("oldest batch was not dropped, first batch is %q", data)
+	}
+}
+
+func TestSpoolDropNewest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deflux-spool")
+	if err != nil {
+		t.Fatalf("unable to create spool dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := &flakyWriter{broken: true}
+	s, err := newSpool(SpoolConfig{Dir: dir, MaxSize: 100, Drop: "newest"}, w.write)
+	if err != nil {
+		t.Fatalf("unable to open spool: %s", err)
+	}
+	defer s.close()
+
+	for _, id := range []string{"1", "2"} {
+		err = s.add([]*client.Point{testPoint(id)})
+		if err != nil {
+			t.Fatalf("unable to spool batch: %s", err)
+		}
+	}
+
+	// the spool is full, the batch should be reported as dropped
+	err = s.add([]*client.Point{testPoint("3")})
+	if err == nil {
+		t.Errorf("expected dropping the newest batch to fail")
+	}
+
+	if len(s.files) != 2 {
+		t.Fatalf("expected 2 spooled batches, found %d", len(s.files))
+	}
+
+	data, _ := ioutil.ReadFile(dir + "/" + s.files[1].name)
+	if string(data) != testPoint("2").String()+"\n" {
+		t.Errorf("newest batch was not dropped, last batch is %q", data)
+	}
+}
+
+func TestSpoolQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deflux-spool")
+	if err != nil {
+		t.Fatalf("unable to create spool dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// influxdb refuses batch 1, e.g. because of a field type conflict
+	w := &flakyWriter{}
+	write := func(points []*client.Point) error {
+		if points[0].Tags()["id"] == "1" {
+			return &statusError{sink: "influxdb", code: 400, msg: []byte("field type conflict")}
+		}
+		return w.write(points)
+	}
+
+	s, err := newSpool(SpoolConfig{Dir: dir}, write)
+	if err != nil {
+		t.Fatalf("unable to open spool: %s", err)
+	}
+
+	s.add([]*client.Point{testPoint("1")})
+	s.add([]*client.Point{testPoint("2")})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.empty() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.close()
+
+	if len(w.written) != 1 || w.written[0] != "2" {
+		t.Errorf("batch behind a rejected batch was not replayed: %v", w.written)
+	}
+
+	files, _ := ioutil.ReadDir(dir + "/rejected")
+	if len(files) != 1 {
+		t.Errorf("expected 1 quarantined batch, found %d", len(files))
+	}
+}